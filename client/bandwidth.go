@@ -0,0 +1,167 @@
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// packets the delay trend is fitted over
+	trendlineWindow = 20
+	// delay gradients above this mean queues are building up on the path
+	overuseThreshold = 0.01
+	// an estimate that moved this much is reported to OnBandwidthChange
+	significantBandwidthChange = 0.1
+)
+
+// receiver side of the delay based estimate.
+// the one-way delay gradient is the slope of how much later packets arrive
+// than they were sent, relative to each other. a positive slope means a
+// queue is growing somewhere on the path, long before packets are lost.
+type delayTrend struct {
+	lastSend    uint32
+	lastArrival time.Time
+	// accumulated delay variation in milliseconds, smoothed
+	accumulated float64
+	smoothed    float64
+	// (arrival ms, smoothed delay ms) of recent packets
+	samples [][2]float64
+	first   time.Time
+}
+
+// add a packet that was sent at send (remote clock, µs) and arrived now
+func (d *delayTrend) add(send uint32, arrival time.Time) {
+	if d.first.IsZero() {
+		d.first = arrival
+		d.lastSend, d.lastArrival = send, arrival
+		return
+	}
+	sendDelta := float64(int32(send-d.lastSend)) / 1000
+	if sendDelta < 0 {
+		return // reordered
+	}
+	arrivalDelta := float64(arrival.Sub(d.lastArrival).Microseconds()) / 1000
+	d.lastSend, d.lastArrival = send, arrival
+
+	d.accumulated += arrivalDelta - sendDelta
+	d.smoothed = 0.9*d.smoothed + 0.1*d.accumulated
+	d.samples = append(d.samples, [2]float64{float64(arrival.Sub(d.first).Microseconds()) / 1000, d.smoothed})
+	if len(d.samples) > trendlineWindow {
+		d.samples = d.samples[1:]
+	}
+}
+
+// slope of the delay, milliseconds of extra delay per millisecond
+func (d *delayTrend) gradient() float64 {
+	n := float64(len(d.samples))
+	if n < 2 {
+		return 0
+	}
+	var meanX, meanY float64
+	for _, s := range d.samples {
+		meanX += s[0]
+		meanY += s[1]
+	}
+	meanX /= n
+	meanY /= n
+	var num, den float64
+	for _, s := range d.samples {
+		num += (s[0] - meanX) * (s[1] - meanY)
+		den += (s[0] - meanX) * (s[0] - meanX)
+	}
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// NewDelayBasedController returns a controller that follows Google Congestion Control.
+// It backs off as soon as the receiver reports a growing one-way delay,
+// before the path starts dropping packets, and also backs off on loss.
+// Rates are in bytes per second.
+//
+// It is the estimator behind [Stats].EstimatedBandwidth.
+func NewDelayBasedController(initial, min, max int) CongestionController {
+	return &delayBasedController{
+		delayRate: float64(initial),
+		loss:      lossBasedController{rate: float64(initial), min: float64(min), max: float64(max)},
+		min:       float64(min),
+		max:       float64(max),
+	}
+}
+
+type delayBasedController struct {
+	mu         sync.Mutex
+	delayRate  float64
+	min, max   float64
+	lastUpdate time.Time
+	// backs off on loss, the lower of both rates is used
+	loss lossBasedController
+}
+
+func (c *delayBasedController) OnFeedback(fb Feedback) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	elapsed := min(now.Sub(c.lastUpdate).Seconds(), 1)
+	if c.lastUpdate.IsZero() {
+		elapsed = 0
+	}
+	c.lastUpdate = now
+
+	// delay based, react to the queue before it overflows
+	switch {
+	case fb.DelayGradient > overuseThreshold:
+		if fb.ReceiveRate > 0 {
+			c.delayRate = 0.85 * float64(fb.ReceiveRate)
+		} else {
+			c.delayRate *= 0.85
+		}
+	case fb.DelayGradient < -overuseThreshold:
+		// queues are draining, hold until they're empty
+	default:
+		c.delayRate *= math.Pow(1.08, elapsed)
+		// don't run away from what is actually being sent
+		if fb.ReceiveRate > 0 {
+			c.delayRate = min(c.delayRate, 1.5*float64(fb.ReceiveRate)+float64(defaultDatagramSize)/feedbackInterval.Seconds())
+		}
+	}
+
+	c.delayRate = min(max(c.delayRate, c.min), c.max)
+	c.loss.OnFeedback(fb)
+}
+
+func (c *delayBasedController) SendRate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return min(int(c.delayRate), c.loss.SendRate())
+}
+
+// OnBandwidthChange calls f with the new estimate, in bytes per second,
+// whenever the estimated bandwidth changes significantly.
+// f is called from the goroutine reading the connection and must not block.
+// Calling it again replaces f.
+func (s *session) OnBandwidthChange(f func(bandwidth int)) {
+	s.cc.mu.Lock()
+	s.cc.onBandwidthChange = f
+	s.cc.mu.Unlock()
+}
+
+// feed a report to the estimator, and tell the application about significant changes
+func (s *session) estimate(fb Feedback) {
+	s.cc.estimator.OnFeedback(fb)
+	bandwidth := s.cc.estimator.SendRate()
+
+	s.cc.mu.Lock()
+	f := s.cc.onBandwidthChange
+	last := s.cc.notifiedBandwidth
+	changed := last == 0 || math.Abs(float64(bandwidth-last)) > significantBandwidthChange*float64(last)
+	if changed {
+		s.cc.notifiedBandwidth = bandwidth
+	}
+	s.cc.mu.Unlock()
+	if changed && f != nil {
+		f(bandwidth)
+	}
+}
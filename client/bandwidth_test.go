@@ -0,0 +1,46 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayTrend(t *testing.T) {
+	start := time.Now()
+	var steady, growing delayTrend
+	for i := range 40 {
+		send := uint32(i * 10_000) // every 10ms
+		steady.add(send, start.Add(time.Duration(i)*10*time.Millisecond))
+		// each packet waits 1ms longer in a queue than the one before
+		growing.add(send, start.Add(time.Duration(i)*11*time.Millisecond))
+	}
+	if g := steady.gradient(); g > overuseThreshold || g < -overuseThreshold {
+		t.Errorf("steady path has gradient %v", g)
+	}
+	if g := growing.gradient(); g <= overuseThreshold {
+		t.Errorf("growing queue has gradient %v", g)
+	}
+
+	c := NewDelayBasedController(100_000, 10_000, 1_000_000)
+	c.OnFeedback(Feedback{Expected: 10, DelayGradient: 0.05, ReceiveRate: 80_000})
+	if rate := c.SendRate(); rate >= 80_000 {
+		t.Errorf("rate %d did not drop below the receive rate on overuse", rate)
+	}
+}
+
+func TestLossBackoff(t *testing.T) {
+	loss := NewLossBasedController(100_000, 10_000, 1_000_000)
+	delay := NewDelayBasedController(100_000, 10_000, 1_000_000)
+	for _, c := range []CongestionController{loss, delay} {
+		c.OnFeedback(Feedback{Expected: 100, Lost: 30})
+		if rate := c.SendRate(); rate != 85_000 {
+			t.Errorf("%T: rate is %d after 30%% loss", c, rate)
+		}
+		for range 100 {
+			c.OnFeedback(Feedback{Expected: 100, Lost: 90})
+		}
+		if rate := c.SendRate(); rate != 10_000 {
+			t.Errorf("%T: rate %d went below the minimum", c, rate)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package client
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v3/deadline"
+)
+
+// Mode decides how the messages written to a [Channel] are delivered.
+type Mode uint8
+
+const (
+	// messages may be lost or arrive out of order, like udp
+	Unreliable Mode = iota
+	// messages arrive exactly once and in order
+	Reliable
+	// messages arrive exactly once, in the order they are received
+	ReliableUnordered
+	// messages may be lost, but never arrive after a newer one.
+	// for state like snapshots where only the latest matters
+	UnreliableSequenced
+)
+
+// Channel is one of the logical streams multiplexed over a [Conn].
+// Every channel has its own reliability mode and message boundaries,
+// so different subsystems can own their own traffic.
+// It is safe to use a channel from multiple goroutines.
+//
+// Channel 0 is used by the Read and Write methods of [Conn].
+type Channel struct {
+	id       uint8
+	mode     Mode
+	priority Priority
+	s        *session
+
+	mu sync.Mutex
+	// reliable sending
+	nextSeq uint32
+	unacked map[uint32]*pendingPacket
+	acked   *sync.Cond // signaled when the window frees up
+
+	// reliable receiving
+	expectedSeq uint32
+	// messages that arrived ahead of expectedSeq.
+	// nil for unordered channels, they only need to know it arrived.
+	received map[uint32][]byte
+
+	// large messages are split into fragments
+	nextMsgID    uint32
+	reassemblies map[uint32]*reassembly
+
+	// forward error correction of unreliable messages
+	fec       fecEncoder
+	fecGroups map[uint16]*fecGroup
+
+	// unreliable sequenced sending and receiving
+	nextSequenced uint32
+	lastSequenced uint32
+	sequenced     bool // a sequenced message was delivered
+
+	stats ChannelStats
+
+	incoming     chan []byte
+	readDeadline *deadline.Deadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type pendingPacket struct {
+	packet []byte
+	sentAt time.Time
+}
+
+func newChannel(s *session, id uint8, mode Mode) *Channel {
+	ch := &Channel{
+		id:           id,
+		mode:         mode,
+		s:            s,
+		unacked:      map[uint32]*pendingPacket{},
+		received:     map[uint32][]byte{},
+		reassemblies: map[uint32]*reassembly{},
+		fecGroups:    map[uint16]*fecGroup{},
+		incoming:     make(chan []byte, incomingQueueSize),
+		readDeadline: deadline.New(),
+		closed:       make(chan struct{}),
+	}
+	ch.acked = sync.NewCond(&ch.mu)
+	return ch
+}
+
+// ID of the channel
+func (ch *Channel) ID() uint8 { return ch.id }
+
+// Mode the channel sends with
+func (ch *Channel) Mode() Mode {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.mode
+}
+
+// SetPriority of the messages written to the channel.
+// While writes are paced, higher priority messages are sent first.
+func (ch *Channel) SetPriority(priority Priority) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.priority = min(max(priority, PriorityLow), PriorityHigh)
+}
+
+// Priority of the messages written to the channel
+func (ch *Channel) Priority() Priority {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.priority
+}
+
+// Read a message. A message larger than p is truncated
+// and [io.ErrShortBuffer] is returned, use [Channel.ReadMessage]
+// for messages of unknown size.
+func (ch *Channel) Read(p []byte) (int, error) {
+	msg, err := ch.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, msg)
+	if n < len(msg) {
+		return n, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// ReadMessage returns the next message, however large it is.
+func (ch *Channel) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-ch.incoming:
+		return msg, nil
+	case <-ch.readDeadline.Done():
+		return nil, os.ErrDeadlineExceeded
+	case <-ch.closed:
+		return nil, net.ErrClosed
+	case <-ch.s.closed:
+		return nil, ch.s.err
+	}
+}
+
+// Write sends p as one message, delivered according to the mode of the channel.
+// Messages larger than a datagram are split into fragments and put back
+// together by the receiver. An unreliable message is lost if any of its fragments is.
+func (ch *Channel) Write(p []byte) (int, error) {
+	return ch.WriteBefore(p, time.Time{})
+}
+
+// WriteBefore writes p like Write, but drops it if it could not be sent
+// before deadline, because it waited behind pacing or higher priority messages.
+// A zero deadline never expires. Reliable messages are always sent.
+func (ch *Channel) WriteBefore(p []byte, deadline time.Time) (int, error) {
+	switch ch.Mode() {
+	case Reliable:
+		return ch.writeReliable(kindReliable, p)
+	case ReliableUnordered:
+		return ch.writeReliable(kindReliableUnordered, p)
+	case UnreliableSequenced:
+		return ch.writeSequenced(p, deadline)
+	default:
+		return ch.writeUnreliable(p, deadline)
+	}
+}
+
+// a packet that missed its deadline is counted and dropped
+func (ch *Channel) late(deadline, now time.Time) bool {
+	if deadline.IsZero() || now.Before(deadline) {
+		return false
+	}
+	ch.mu.Lock()
+	ch.stats.DroppedDeadline++
+	ch.mu.Unlock()
+	return true
+}
+
+// split p into message bodies that fit in a datagram
+func (ch *Channel) bodies(p []byte) ([][]byte, error) {
+	if len(p) > ch.s.cfg.maxMessageSize() {
+		return nil, ErrMessageTooLarge
+	}
+	var flags byte
+	if compressed, ok := ch.s.compression.compress(p); ok {
+		p, flags = compressed, flagCompressed
+	}
+	maxBody := ch.maxBody()
+	chunk := maxBody - bodyHeaderSize
+	if (len(p)+chunk-1)/chunk > maxFragments {
+		return nil, ErrMessageTooLarge
+	}
+	ch.mu.Lock()
+	msgID := ch.nextMsgID
+	ch.nextMsgID++
+	ch.mu.Unlock()
+	return fragment(msgID, p, maxBody, flags), nil
+}
+
+func (ch *Channel) writeUnreliable(p []byte, deadline time.Time) (int, error) {
+	if err := ch.writable(); err != nil {
+		return 0, err
+	}
+	bodies, err := ch.bodies(p)
+	if err != nil {
+		return 0, err
+	}
+	for _, body := range bodies {
+		ch.mu.Lock()
+		packets := ch.protect(body)
+		ch.mu.Unlock()
+		for _, packet := range packets {
+			err = ch.s.sendData(ch, packet, deadline)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// all fragments of a message share its sequence number
+func (ch *Channel) writeSequenced(p []byte, deadline time.Time) (int, error) {
+	if err := ch.writable(); err != nil {
+		return 0, err
+	}
+	bodies, err := ch.bodies(p)
+	if err != nil {
+		return 0, err
+	}
+	ch.mu.Lock()
+	seq := ch.nextSequenced
+	ch.nextSequenced++
+	ch.mu.Unlock()
+	for _, body := range bodies {
+		packet := binary.BigEndian.AppendUint32([]byte{kindSequenced, ch.id}, seq)
+		err = ch.s.sendData(ch, append(packet, body...), deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (ch *Channel) writeReliable(kind byte, p []byte) (int, error) {
+	bodies, err := ch.bodies(p)
+	if err != nil {
+		return 0, err
+	}
+	for _, body := range bodies {
+		err = ch.writeReliableBody(kind, body)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// blocks while too many messages are waiting for acknowledgement.
+func (ch *Channel) writeReliableBody(kind byte, body []byte) error {
+	ch.mu.Lock()
+	for len(ch.unacked) >= reliableWindow {
+		if err := ch.writable(); err != nil {
+			ch.mu.Unlock()
+			return err
+		}
+		ch.acked.Wait()
+	}
+	if err := ch.writable(); err != nil {
+		ch.mu.Unlock()
+		return err
+	}
+	seq := ch.nextSeq
+	ch.nextSeq++
+	packet := binary.BigEndian.AppendUint32([]byte{kind, ch.id}, seq)
+	packet = append(packet, body...)
+	ch.unacked[seq] = &pendingPacket{packet: packet, sentAt: time.Now()}
+	ch.mu.Unlock()
+
+	return ch.s.sendData(ch, packet, time.Time{})
+}
+
+func (ch *Channel) writable() error {
+	select {
+	case <-ch.closed:
+		return net.ErrClosed
+	case <-ch.s.closed:
+		return ch.s.err
+	default:
+		return nil
+	}
+}
+
+// the reader being too slow drops the message, like udp would
+func (ch *Channel) receiveUnreliable(body []byte) {
+	ch.mu.Lock()
+	ch.deliver(body, false)
+	ch.mu.Unlock()
+}
+
+// deliver a message only if it is newer than the last one delivered
+func (ch *Channel) receiveSequenced(seq uint32, body []byte) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.sequenced && !seqLess(ch.lastSequenced, seq) {
+		ch.stats.DroppedStale++
+		return
+	}
+	ch.unwrap(body, false, func(msg []byte) bool {
+		// a newer message was completed first
+		if ch.sequenced && !seqLess(ch.lastSequenced, seq) {
+			ch.stats.DroppedStale++
+			return true
+		}
+		if !ch.enqueue(msg) {
+			return true // dropped, the reader is too slow
+		}
+		if ch.sequenced && seq-ch.lastSequenced > 1 {
+			ch.stats.Gaps++
+		}
+		ch.lastSequenced, ch.sequenced = seq, true
+		return true
+	})
+}
+
+// deliver reliable messages exactly once.
+// nothing is acknowledged that could not be queued for Read,
+// so the sender keeps retrying until the reader catches up.
+func (ch *Channel) receiveReliable(ordered bool, seq uint32, body []byte) {
+	ch.mu.Lock()
+	_, seen := ch.received[seq]
+	switch {
+	case seqLess(seq, ch.expectedSeq) || seen:
+		// duplicate, the ack got lost
+	case seq == ch.expectedSeq:
+		if ch.deliver(body, true) {
+			ch.expectedSeq++
+			ch.drainReceived()
+		}
+	case seq-ch.expectedSeq < reliableWindow:
+		if ordered {
+			ch.received[seq] = clone(body)
+		} else if ch.deliver(body, true) {
+			ch.received[seq] = nil
+		}
+	}
+	ack := ch.expectedSeq
+	ch.mu.Unlock()
+
+	ch.s.send(binary.BigEndian.AppendUint32([]byte{kindAck, ch.id}, ack))
+}
+
+// move expectedSeq past the messages that already arrived
+func (ch *Channel) drainReceived() {
+	for {
+		msg, ok := ch.received[ch.expectedSeq]
+		if !ok {
+			return
+		}
+		// unordered messages were delivered when they arrived
+		if msg != nil && !ch.deliver(msg, true) {
+			return
+		}
+		delete(ch.received, ch.expectedSeq)
+		ch.expectedSeq++
+	}
+}
+
+// hand a message body to Read, putting fragmented messages back together first.
+// returns false if the body could not be handled yet.
+// must be called with ch.mu held.
+func (ch *Channel) deliver(body []byte, reliable bool) bool {
+	return ch.unwrap(body, reliable, ch.enqueue)
+}
+
+// pass the message in a body to accept, once all of its fragments arrived.
+// must be called with ch.mu held.
+func (ch *Channel) unwrap(body []byte, reliable bool, accept func([]byte) bool) bool {
+	if len(body) == 0 {
+		return true
+	}
+	flags, body := body[0], body[1:]
+	if flags&flagCompressed != 0 {
+		accept = ch.decompressing(accept)
+	}
+	if flags&flagFragment != 0 {
+		return ch.reassemble(body, reliable, accept)
+	}
+	return accept(clone(body))
+}
+
+// try to hand a message to Read without blocking
+func (ch *Channel) enqueue(payload []byte) bool {
+	select {
+	case ch.incoming <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// everything before next has arrived on the remote
+func (ch *Channel) receiveAck(next uint32) {
+	ch.mu.Lock()
+	for seq := range ch.unacked {
+		if seqLess(seq, next) {
+			delete(ch.unacked, seq)
+		}
+	}
+	ch.mu.Unlock()
+	ch.acked.Broadcast()
+}
+
+// reliable packets that were not acknowledged within timeout.
+// a zero timeout returns all of them.
+func (ch *Channel) expired(now time.Time, timeout time.Duration) (resend [][]byte) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for _, p := range ch.unacked {
+		if now.Sub(p.sentAt) >= timeout {
+			p.sentAt = now
+			resend = append(resend, p.packet)
+		}
+	}
+	return
+}
+
+// ChannelStats counts what happened to the messages of a [Channel].
+type ChannelStats struct {
+	// messages not sent because they missed their deadline, see [Channel.WriteBefore]
+	DroppedDeadline uint64
+	// sequenced messages dropped because a newer one was already delivered
+	DroppedStale uint64
+	// times a sequenced message was delivered after some before it were lost
+	Gaps uint64
+}
+
+// Stats of the messages sent and received on the channel.
+func (ch *Channel) Stats() ChannelStats {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.stats
+}
+
+// Close the channel. Messages that still arrive on it are dropped.
+func (ch *Channel) Close() error {
+	ch.closeOnce.Do(func() {
+		close(ch.closed)
+		ch.mu.Lock()
+		ch.acked.Broadcast()
+		ch.mu.Unlock()
+	})
+	return nil
+}
+
+func (ch *Channel) LocalAddr() net.Addr  { return ch.s.LocalAddr() }
+func (ch *Channel) RemoteAddr() net.Addr { return ch.s.RemoteAddr() }
+
+func (ch *Channel) SetDeadline(t time.Time) error {
+	return ch.SetReadDeadline(t)
+}
+func (ch *Channel) SetReadDeadline(t time.Time) error {
+	ch.readDeadline.Set(t)
+	return nil
+}
+
+// writes never block on the network, so there is nothing to time out
+func (ch *Channel) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUnreliableSequenced(t *testing.T) {
+	ch := newChannel(&session{}, 0, UnreliableSequenced)
+	for _, seq := range []uint32{1, 0, 2, 2, 5, 4, 6} {
+		ch.receiveSequenced(seq, []byte{0, byte(seq)})
+	}
+	var got []byte
+	for len(ch.incoming) > 0 {
+		got = append(got, (<-ch.incoming)[0])
+	}
+	if !bytes.Equal(got, []byte{1, 2, 5, 6}) {
+		t.Errorf("delivered %v", got)
+	}
+	if stats := ch.Stats(); stats.DroppedStale != 3 || stats.Gaps != 1 {
+		t.Errorf("got %+v, expected 3 stale and 1 gap", stats)
+	}
+}
+
+func TestReliableChannels(t *testing.T) {
+	a, b, ab, ba := sessionPair(t, DefaultConfig("", ""))
+	for _, end := range []*datagramEnd{ab, ba} {
+		end.set(func(e *datagramEnd) { e.dropEvery, e.reorder = 3, true })
+	}
+	ordered, unordered := a.Channel(1, Reliable), a.Channel(2, ReliableUnordered)
+	for i := range 100 {
+		ordered.Write([]byte{byte(i)})
+		unordered.Write([]byte{byte(i)})
+	}
+	for i, msg := range readMessages(t, b.Channel(1, Reliable), 100) {
+		if msg[0] != byte(i) {
+			t.Fatalf("reliable channel delivered %d as message %d", msg[0], i)
+		}
+	}
+	seen := map[byte]bool{}
+	for _, msg := range readMessages(t, b.Channel(2, ReliableUnordered), 100) {
+		if seen[msg[0]] {
+			t.Fatalf("unordered channel delivered %d twice", msg[0])
+		}
+		seen[msg[0]] = true
+	}
+	expectNoMessage(t, b.Channel(1, Reliable))
+	expectNoMessage(t, b.Channel(2, ReliableUnordered))
+	// nothing leaked onto the main channel
+	expectNoMessage(t, b.main)
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// clock synchronization, like a tiny ntp. the guest asks the owner for its
+// time, and estimates the offset between their clocks from the exchange:
+//
+//	offset = ((t1 - t0) + (t2 - t3)) / 2
+//	rtt    = (t3 - t0) - (t2 - t1)
+//
+// where t0 and t3 are when the guest sent the request and got the response,
+// and t1 and t2 when the owner received and answered it.
+// the offset of the fastest recent exchange is used, because queues on the path
+// make the others less accurate. the clock is slewed towards a new estimate
+// instead of jumping, so ServerTime doesn't stutter.
+const (
+	// exchanges the estimate is picked from
+	clockSamples = 8
+	// requests sent quickly after connecting, to sync before the game starts
+	clockFastSyncs    = clockSamples
+	clockFastInterval = 100 * time.Millisecond
+	clockInterval     = 2 * time.Second
+	// offsets further off than this are stepped to right away
+	clockStepThreshold = 100 * time.Millisecond
+	// how fast the clock is corrected, 5ms per second
+	clockSlewRate = 0.005
+)
+
+type clockSample struct {
+	offset, rtt time.Duration
+}
+
+type clockSync struct {
+	mu      sync.Mutex
+	samples []clockSample
+	synced  bool
+	// best estimate of the offset to the owner's clock
+	estimate time.Duration
+	rtt      time.Duration
+	// offset currently applied, it moves towards estimate
+	offset   time.Duration
+	slewedAt time.Time
+}
+
+// ServerTime is the time on the owner's clock, see [Owner.Now].
+// On the owner's side of a connection it is the local time.
+// On a guest it is synchronized with the owner continuously,
+// before the first exchange completed it is the local time.
+func (s *session) ServerTime() time.Time {
+	now := time.Now()
+	c := &s.serverClock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slew(now)
+	return now.Add(c.offset)
+}
+
+// Now is the time every guest's [Conn.ServerTime] is synchronized to.
+func (owner *Owner) Now() time.Time {
+	return time.Now()
+}
+
+// move the applied offset towards the estimate. must be called with c.mu held
+func (c *clockSync) slew(now time.Time) {
+	elapsed := now.Sub(c.slewedAt)
+	c.slewedAt = now
+	diff := c.estimate - c.offset
+	limit := time.Duration(float64(elapsed) * clockSlewRate)
+	c.offset += min(max(diff, -limit), limit)
+}
+
+func (c *clockSync) add(sample clockSample, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > clockSamples {
+		c.samples = c.samples[1:]
+	}
+	best := c.samples[0]
+	for _, s := range c.samples[1:] {
+		if s.rtt < best.rtt {
+			best = s
+		}
+	}
+	c.slew(now)
+	c.estimate, c.rtt = best.offset, best.rtt
+	if diff := c.estimate - c.offset; !c.synced || diff > clockStepThreshold || diff < -clockStepThreshold {
+		c.offset = c.estimate
+		c.synced = true
+	}
+}
+
+// ask the owner for its time, for as long as the session lasts
+func (s *session) syncClock() {
+	for i := 0; ; i++ {
+		interval := clockInterval
+		if i < clockFastSyncs {
+			interval = clockFastInterval
+		}
+		select {
+		case <-s.closed:
+			return
+		case <-time.After(interval):
+		}
+		request := binary.BigEndian.AppendUint64([]byte{kindClockRequest}, uint64(time.Now().UnixNano()))
+		s.send(request)
+	}
+}
+
+// answer with the time the request arrived and the response was sent
+func (s *session) receiveClockRequest(request []byte) {
+	received := time.Now().UnixNano()
+	if len(request) < 9 {
+		return
+	}
+	response := append([]byte{kindClockResponse}, request[1:9]...)
+	response = binary.BigEndian.AppendUint64(response, uint64(received))
+	response = binary.BigEndian.AppendUint64(response, uint64(time.Now().UnixNano()))
+	s.send(response)
+}
+
+func (s *session) receiveClockResponse(response []byte) {
+	now := time.Now()
+	if len(response) < 25 {
+		return
+	}
+	t0 := int64(binary.BigEndian.Uint64(response[1:]))
+	t1 := int64(binary.BigEndian.Uint64(response[9:]))
+	t2 := int64(binary.BigEndian.Uint64(response[17:]))
+	t3 := now.UnixNano()
+	rtt := (t3 - t0) - (t2 - t1)
+	if rtt < 0 {
+		return // the local clock jumped
+	}
+	s.serverClock.add(clockSample{
+		offset: time.Duration(((t1 - t0) + (t2 - t3)) / 2),
+		rtt:    time.Duration(rtt),
+	}, now)
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockSync(t *testing.T) {
+	var c clockSync
+	now := time.Now()
+	c.add(clockSample{offset: time.Second, rtt: 50 * time.Millisecond}, now)
+	if c.offset != time.Second {
+		t.Errorf("first sample not stepped to, offset %v", c.offset)
+	}
+	// a queued exchange is less accurate than the fast one
+	c.add(clockSample{offset: time.Second + 40*time.Millisecond, rtt: 200 * time.Millisecond}, now)
+	if c.estimate != time.Second {
+		t.Errorf("slow sample was used, estimate %v", c.estimate)
+	}
+	// small corrections are slewed
+	c.add(clockSample{offset: time.Second + 10*time.Millisecond, rtt: 10 * time.Millisecond}, now)
+	if c.offset != time.Second {
+		t.Errorf("clock jumped to %v", c.offset)
+	}
+	c.slew(now.Add(time.Second))
+	if c.offset != time.Second+5*time.Millisecond {
+		t.Errorf("slewed to %v after a second", c.offset)
+	}
+	// large ones are stepped
+	c.add(clockSample{offset: -time.Second, rtt: time.Millisecond}, now.Add(time.Second))
+	if c.offset != -time.Second {
+		t.Errorf("clock did not step, offset %v", c.offset)
+	}
+}
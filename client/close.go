@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BrownNPC/Ice-Data-Channel/message"
+	"github.com/coder/websocket"
+)
+
+// close notices tell the remote right away that a session is over,
+// instead of leaving it to wait for ice or the idle timeout.
+// they are unreliable, so a few copies are sent.
+const closeNotices = 3
+
+// why a session is closed, sent with the close notice
+const (
+	// the connection was closed, or the guest left
+	closeLeave byte = iota + 1
+	// the owner closed the room
+	closeRoom
+	// the owner kicked the guest, followed by the reason it gave
+	closeKick
+)
+
+// longest kick reason sent with a close notice
+const maxKickReason = 255
+
+// cut reason short to fit a close notice, without splitting a character
+func truncateReason(reason string) string {
+	if len(reason) <= maxKickReason {
+		return reason
+	}
+	cut := maxKickReason
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
+	}
+	return reason[:cut]
+}
+
+// the guest was kicked, wraps ErrKicked
+type kickedError struct {
+	reason string
+}
+
+func (e kickedError) Error() string {
+	if e.reason == "" {
+		return ErrKicked.Error()
+	}
+	return ErrKicked.Error() + ": " + e.reason
+}
+func (e kickedError) Unwrap() error { return ErrKicked }
+
+// the reason the owner gave for kicking the guest, if err says it was kicked
+func kickReason(err error) string {
+	var kicked kickedError
+	if errors.As(err, &kicked) {
+		return kicked.reason
+	}
+	return ""
+}
+
+// Close the session and the ice connection under it.
+// The remote is told right away, and its Conn returns ErrRemoteClosed.
+func (s *session) Close() error {
+	s.shutdown(net.ErrClosed, closeLeave)
+	return nil
+}
+
+// tell the remote why the session ends, then close it with err
+func (s *session) shutdown(err error, notice ...byte) {
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	packet := append([]byte{kindClose}, notice...)
+	for range closeNotices {
+		s.send(packet)
+	}
+	s.close(err)
+}
+
+func (s *session) receiveClose(packet []byte) {
+	if len(packet) < 2 {
+		return
+	}
+	switch packet[1] {
+	case closeRoom:
+		s.setReason(DisconnectRemoteClosed)
+		s.close(ErrRoomClosed)
+	case closeKick:
+		s.setReason(DisconnectKicked)
+		s.close(kickedError{string(packet[2:])})
+	default:
+		s.setReason(DisconnectRemoteClosed)
+		s.close(ErrRemoteClosed)
+	}
+}
+
+// Close the peer connection, and stop the goroutines serving it
+func (pc *peerConnection) Close() error {
+	pc.closeOnce.Do(func() { close(pc.done) })
+	return pc.agent.Close()
+}
+
+// Close the room. Every guest is told over its connection and the
+// signaling server, and the owner's connections and goroutines are released.
+func (owner *Owner) Close() error {
+	owner.closeOnce.Do(func() {
+		// guests learn over the peer to peer path first,
+		// the signaling server tells those that can't be reached
+		owner.disconnectAll()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		owner.ws.WriteMsg(ctx, message.RoomClosedMsg())
+		cancel()
+		owner.cancel()
+		<-owner.done
+		owner.compressor.close()
+	})
+	return nil
+}
+
+// Leave the room. The owner is told over the connection and the signaling
+// server, and the guest's connections and goroutines are released.
+func (guest *Guest) Leave() error {
+	guest.conn.Close()
+	guest.release()
+	return nil
+}
+
+// let go of everything once the session is over
+func (guest *Guest) release() {
+	guest.releaseOnce.Do(func() {
+		ws, pc := guest.current()
+		// tell the owner if the guest closed the session itself
+		if guest.conn.err == net.ErrClosed {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			ws.WriteMsg(ctx, message.LeaveMsg())
+			cancel()
+		}
+		guest.cancel()
+		ws.Close(websocket.StatusNormalClosure, "left the room")
+		pc.Close()
+		guest.compressor.close()
+	})
+}
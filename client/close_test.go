@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BrownNPC/Ice-Data-Channel/message"
+	"github.com/BrownNPC/Ice-Data-Channel/server"
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+)
+
+func TestCloseNotice(t *testing.T) {
+	for _, test := range []struct {
+		notice []byte
+		err    error
+		reason DisconnectReason
+	}{
+		{[]byte{kindClose, closeLeave}, ErrRemoteClosed, DisconnectRemoteClosed},
+		{[]byte{kindClose, closeRoom}, ErrRoomClosed, DisconnectRemoteClosed},
+		{append([]byte{kindClose, closeKick}, "afk"...), ErrKicked, DisconnectKicked},
+	} {
+		s := &session{closed: make(chan struct{})}
+		s.receiveClose(test.notice)
+		if !errors.Is(s.err, test.err) {
+			t.Errorf("notice %v: got %v, want %v", test.notice, s.err, test.err)
+		}
+		if got := s.disconnectReason(); got != test.reason {
+			t.Errorf("notice %v: got %v, want %v", test.notice, got, test.reason)
+		}
+	}
+	s := &session{closed: make(chan struct{})}
+	s.receiveClose(append([]byte{kindClose, closeKick}, "afk"...))
+	if reason := kickReason(s.err); reason != "afk" {
+		t.Errorf("kick reason is %q", reason)
+	}
+	if s.err.Error() != "kicked by the owner: afk" {
+		t.Errorf("error is %q", s.err)
+	}
+}
+
+// a room whose owner answers the ice auth of a guest, but never connects to it.
+// the ids of joining guests are sent to joining
+func joiningRoom(t *testing.T) (cfg Config, roomID string, owner ws, joining chan uuid.UUID) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go server.Serve(l, "/ws")
+	cfg = DefaultConfig(l.Addr().String(), "/ws")
+	cfg.AgentCfg.Urls = nil
+
+	ctx := t.Context()
+	conn, _, err := websocket.Dial(ctx, cfg.SignalingServer.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	owner = ws{conn}
+	if err := owner.WriteMsg(ctx, message.CreateRoomMsg()); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := owner.ReadMsg(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joining = make(chan uuid.UUID, 1)
+	go func() {
+		for {
+			join, err := owner.ReadMsg(ctx)
+			if err != nil {
+				return
+			}
+			if join.Type == message.IceAuthInitiate {
+				owner.WriteMsg(ctx, message.IceAuthResponseMsg("ownerUfrag", "ownerPasswordOwnerPassword", join.From, ""))
+				joining <- join.From
+			}
+		}
+	}()
+	return cfg, msg.RoomID, owner, joining
+}
+
+func TestEndedWhileJoining(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		end  func(peer uuid.UUID) message.Msg
+		err  error
+	}{
+		{"room closed", func(uuid.UUID) message.Msg { return message.RoomClosedMsg() }, ErrRoomClosed},
+		{"kicked", func(peer uuid.UUID) message.Msg { return message.KickMsg(peer, "room is full") }, ErrKicked},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, roomID, owner, joining := joiningRoom(t)
+			ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+			defer cancel()
+			joined := make(chan error, 1)
+			go func() {
+				_, err := NewGuest(ctx, roomID, cfg)
+				joined <- err
+			}()
+			// the guest waits for ice to connect
+			peer := <-joining
+			time.Sleep(100 * time.Millisecond)
+			if err := owner.WriteMsg(ctx, test.end(peer)); err != nil {
+				t.Fatal(err)
+			}
+			err := <-joined
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if test.err == ErrKicked && kickReason(err) != "room is full" {
+				t.Errorf("kick reason is %q", kickReason(err))
+			}
+		})
+	}
+}
+
+func TestTruncateReason(t *testing.T) {
+	if got := truncateReason("afk"); got != "afk" {
+		t.Errorf("short reason changed to %q", got)
+	}
+	// 254 bytes, then a 3 byte character that doesn't fit
+	reason := strings.Repeat("a", maxKickReason-1) + "€"
+	got := truncateReason(reason)
+	if len(got) != maxKickReason-1 || !utf8.ValidString(got) {
+		t.Errorf("cut to %d bytes, valid utf-8 %v", len(got), utf8.ValidString(got))
+	}
+}
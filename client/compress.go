@@ -0,0 +1,116 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// messages are compressed with zstd if both peers offered it in the
+// signaling handshake with the same dictionary. a message that doesn't get
+// smaller is sent as it is, the body flags tell the receiver which it is.
+const (
+	// messages smaller than this are not worth compressing
+	minCompressSize = 64
+	compressionZstd = "zstd"
+)
+
+var errNotNegotiated = errors.New("compressed message on an uncompressed connection")
+
+// shared by all connections of an owner or guest
+type compressor struct {
+	// what this peer offers in the signaling handshake
+	name string
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+}
+
+// nil if compression is turned off
+func newCompressor(cfg Config) (*compressor, error) {
+	if !cfg.Compression {
+		return nil, nil
+	}
+	offer := compressionZstd
+	encOpts := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderCRC(false)}
+	decOpts := []zstd.DOption{zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(uint64(cfg.maxMessageSize()))}
+	if cfg.CompressionDictionary != nil {
+		dict, err := zstd.InspectDictionary(cfg.CompressionDictionary)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compression dictionary: %w", err)
+		}
+		offer = fmt.Sprintf("%s:%08x", compressionZstd, dict.ID())
+		encOpts = append(encOpts, zstd.WithEncoderDict(cfg.CompressionDictionary))
+		decOpts = append(decOpts, zstd.WithDecoderDicts(cfg.CompressionDictionary))
+	}
+	enc, err := zstd.NewWriter(nil, encOpts...)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil, decOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &compressor{name: offer, enc: enc, dec: dec}, nil
+}
+
+// release the encoder and decoder
+func (c *compressor) close() {
+	if c == nil {
+		return
+	}
+	c.enc.Close()
+	c.dec.Close()
+}
+
+// what to offer the remote, empty without compression
+func (c *compressor) offer() string {
+	if c == nil {
+		return ""
+	}
+	return c.name
+}
+
+// compression to use with a remote that offered this, nil for none
+func (c *compressor) negotiate(offer string) *compressor {
+	if c == nil || offer != c.name {
+		if c != nil && offer != "" {
+			slog.Debug("compression not negotiated", "local", c.name, "remote", offer)
+		}
+		return nil
+	}
+	return c
+}
+
+// compress p, if that makes it smaller
+func (c *compressor) compress(p []byte) ([]byte, bool) {
+	if c == nil || len(p) < minCompressSize {
+		return p, false
+	}
+	out := c.enc.EncodeAll(p, make([]byte, 0, len(p)))
+	if len(out) >= len(p) {
+		return p, false
+	}
+	return out, true
+}
+
+func (c *compressor) decompress(p []byte) ([]byte, error) {
+	if c == nil {
+		return nil, errNotNegotiated
+	}
+	return c.dec.DecodeAll(p, nil)
+}
+
+// pass messages to accept after decompressing them.
+// messages that fail to decompress are dropped.
+func (ch *Channel) decompressing(accept func([]byte) bool) func([]byte) bool {
+	return func(msg []byte) bool {
+		msg, err := ch.s.compression.decompress(msg)
+		if err != nil {
+			slog.Debug("dropping message that failed to decompress", "channel", ch.id, "error", err)
+			return true
+		}
+		return accept(msg)
+	}
+}
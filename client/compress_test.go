@@ -0,0 +1,37 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	c, err := newCompressor(Config{Compression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.negotiate("zstd:0000002a") != nil || c.negotiate("") != nil {
+		t.Error("agreed to compress with a different offer")
+	}
+	s := &session{compression: c.negotiate(c.offer())}
+	sender, receiver := newChannel(s, 0, Unreliable), newChannel(s, 0, Unreliable)
+
+	repetitive := bytes.Repeat([]byte(`{"x":1,"y":2}`), 200)
+	random := []byte("short and not worth it")
+	for _, msg := range [][]byte{repetitive, random} {
+		bodies, err := sender.bodies(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := bodies[0][0]&flagCompressed != 0
+		if compressed != (len(msg) == len(repetitive)) {
+			t.Errorf("message of %d bytes compressed: %v", len(msg), compressed)
+		}
+		for _, body := range bodies {
+			receiver.deliver(body, false)
+		}
+		if got := <-receiver.incoming; !bytes.Equal(got, msg) {
+			t.Errorf("message of %d bytes changed", len(msg))
+		}
+	}
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"net/url"
+	"time"
 
 	"github.com/pion/ice/v4"
 	"github.com/pion/stun/v3"
@@ -12,6 +13,41 @@ type Config struct {
 	AgentCfg ice.AgentConfig
 	// where to dial the signaling server
 	SignalingServer url.URL
+	// how long a session waits for the guest to reconnect
+	// after losing its connection, before it is closed.
+	ResumeTimeout time.Duration
+	// largest message that can be written at once,
+	// larger messages are split into datagrams and reassembled.
+	// 0 means 256KiB
+	MaxMessageSize int
+	// how long to wait for the missing fragments of an unreliable message
+	// before dropping it. 0 means 2 seconds
+	ReassemblyTimeout time.Duration
+	// creates the congestion controller of every connection.
+	// writes are paced to the rate it allows. nil disables pacing.
+	// see [NewLossBasedController]
+	CongestionControl func() CongestionController
+	// send every data packet over this many of the candidate pairs
+	// that passed their connectivity checks, to mask loss on any one path.
+	// the copies are dropped by the receiver. 0 or 1 uses the selected pair only
+	Multipath int
+	// compress messages with zstd if the remote peer enables it too.
+	// messages that don't get smaller are sent uncompressed
+	Compression bool
+	// zstd dictionary to compress with, as made by "zstd --train".
+	// both peers need the same dictionary, otherwise messages are not compressed
+	CompressionDictionary []byte
+	// how often keepalive probes measure the round trip time. 0 means 1 second
+	KeepaliveInterval time.Duration
+	// close connections that nothing arrived from for this long
+	// with ErrIdleTimeout. 0 means 15 seconds
+	IdleTimeout time.Duration
+	// called for every change in the lifecycle of a connection, on the owner
+	// for every guest. it is called from the library's goroutines and must not block
+	OnEvent func(Event)
+	// describes a guest to the owner, like the player's name.
+	// it is sent when joining, see [Conn.Metadata]
+	Metadata map[string]string
 }
 
 func DefaultConfig(SignalingServerAddr, path string) Config {
@@ -20,6 +56,11 @@ func DefaultConfig(SignalingServerAddr, path string) Config {
 			Scheme: "ws", Path: path,
 			Host: SignalingServerAddr,
 		},
+		ResumeTimeout:     time.Second * 30,
+		MaxMessageSize:    256 << 10,
+		ReassemblyTimeout: time.Second * 2,
+		KeepaliveInterval: time.Second,
+		IdleTimeout:       time.Second * 15,
 		AgentCfg: ice.AgentConfig{
 			NetworkTypes:     []ice.NetworkType{ice.NetworkTypeUDP4, ice.NetworkTypeUDP6},
 			MulticastDNSMode: ice.MulticastDNSModeQueryAndGather,
@@ -32,3 +73,28 @@ func DefaultConfig(SignalingServerAddr, path string) Config {
 			}},
 	}
 }
+
+func (cfg Config) maxMessageSize() int {
+	if cfg.MaxMessageSize <= 0 {
+		return 256 << 10
+	}
+	return cfg.MaxMessageSize
+}
+func (cfg Config) reassemblyTimeout() time.Duration {
+	if cfg.ReassemblyTimeout <= 0 {
+		return time.Second * 2
+	}
+	return cfg.ReassemblyTimeout
+}
+func (cfg Config) keepaliveInterval() time.Duration {
+	if cfg.KeepaliveInterval <= 0 {
+		return time.Second
+	}
+	return cfg.KeepaliveInterval
+}
+func (cfg Config) idleTimeout() time.Duration {
+	if cfg.IdleTimeout <= 0 {
+		return time.Second * 15
+	}
+	return cfg.IdleTimeout
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+// Feedback the receiver reported about the packets sent since its last report.
+type Feedback struct {
+	// packets the receiver should have gotten
+	Expected int
+	// how many of them never arrived
+	Lost int
+	// round trip time measured with this report
+	RTT time.Duration
+	// bytes per second that arrived since the last report
+	ReceiveRate int
+	// slope of the one-way delay, positive while a queue builds up on the path.
+	// milliseconds of extra delay per millisecond
+	DelayGradient float64
+}
+
+// CongestionController estimates how fast a [Conn] can send
+// without overloading the path. Writes are paced to its rate.
+// A controller is used by a single Conn.
+type CongestionController interface {
+	// called for every feedback report from the receiver
+	OnFeedback(Feedback)
+	// current send rate in bytes per second
+	SendRate() int
+}
+
+// NewLossBasedController returns a controller that backs off when
+// the receiver reports loss, and probes for more bandwidth when it doesn't.
+// It follows the loss based part of Google Congestion Control.
+// Rates are in bytes per second.
+func NewLossBasedController(initial, min, max int) CongestionController {
+	return &lossBasedController{rate: float64(initial), min: float64(min), max: float64(max)}
+}
+
+type lossBasedController struct {
+	mu             sync.Mutex
+	rate, min, max float64
+}
+
+func (c *lossBasedController) OnFeedback(fb Feedback) {
+	if fb.Expected <= 0 {
+		return
+	}
+	loss := float64(fb.Lost) / float64(fb.Expected)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case loss > 0.1:
+		c.rate *= 1 - 0.5*loss
+	case loss < 0.02:
+		c.rate *= 1.05
+	}
+	c.rate = min(max(c.rate, c.min), c.max)
+}
+
+func (c *lossBasedController) SendRate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.rate)
+}
+
+const (
+	// how often the receiver reports what arrived
+	feedbackInterval = 100 * time.Millisecond
+	// sequence number and send time of a data packet
+	transportHeaderSize = 1 + 4 + 4
+	// bounds of the bandwidth estimate, in bytes per second
+	initialBandwidth = 125_000
+	minBandwidth     = 8_000
+	maxBandwidth     = 12_500_000
+)
+
+// sender side of the feedback loop
+type congestion struct {
+	// paces writes, nil without congestion control
+	controller CongestionController
+	// always runs, for Stats
+	estimator CongestionController
+
+	mu sync.Mutex
+	// transport wide sequence number of the next data packet
+	nextSeq uint32
+	// what the previous report covered
+	lastHighest  uint32
+	lastReceived uint32
+	lastBytes    uint32
+	lastReport   time.Time
+	reported     bool
+	// latest report
+	feedback Feedback
+
+	onBandwidthChange func(bandwidth int)
+	notifiedBandwidth int
+}
+
+// receiver side of the feedback loop
+type arrivals struct {
+	mu       sync.Mutex
+	highest  uint32
+	received uint32
+	bytes    uint32
+	trend    delayTrend
+	seen     seqWindow
+	// send time of the latest packet, echoed back for measuring rtt
+	lastSendTime uint32
+	lastArrival  time.Time
+	// nothing new arrived since the last report
+	reported bool
+}
+
+// microseconds since the session started, wrapping every ~71 minutes
+func (s *session) clock() uint32 {
+	return uint32(time.Since(s.epoch).Microseconds())
+}
+
+// stamp a data packet with a transport wide sequence number and the send time
+func (s *session) sendTransport(packet []byte) error {
+	s.cc.mu.Lock()
+	seq := s.cc.nextSeq
+	s.cc.nextSeq++
+	s.cc.mu.Unlock()
+
+	wrapped := make([]byte, 0, transportHeaderSize+len(packet))
+	wrapped = append(wrapped, kindTransport)
+	wrapped = binary.BigEndian.AppendUint32(wrapped, seq)
+	wrapped = binary.BigEndian.AppendUint32(wrapped, s.clock())
+	wrapped = append(wrapped, packet...)
+	s.sendRedundant(wrapped)
+	return s.send(wrapped)
+}
+
+// unwrap a data packet, remembering it arrived for the next report
+func (s *session) receiveTransport(packet []byte) {
+	if len(packet) < transportHeaderSize {
+		return
+	}
+	seq := binary.BigEndian.Uint32(packet[1:])
+	sendTime := binary.BigEndian.Uint32(packet[5:])
+	now := time.Now()
+
+	a := &s.arrivals
+	a.mu.Lock()
+	// sent over more than one path
+	if a.seen.duplicate(seq) {
+		a.mu.Unlock()
+		return
+	}
+	if a.received == 0 || seqLess(a.highest, seq) {
+		a.highest = seq
+		a.lastSendTime = sendTime
+		a.lastArrival = now
+		a.trend.add(sendTime, now)
+	}
+	a.received++
+	a.bytes += uint32(len(packet))
+	a.reported = false
+	a.mu.Unlock()
+
+	s.handlePacket(packet[transportHeaderSize:])
+}
+
+// report what arrived to the sender
+func (s *session) feedbackLoop() {
+	ticker := time.NewTicker(feedbackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			a := &s.arrivals
+			a.mu.Lock()
+			if a.reported {
+				a.mu.Unlock()
+				continue
+			}
+			a.reported = true
+			report := []byte{kindFeedback}
+			report = binary.BigEndian.AppendUint32(report, a.highest)
+			report = binary.BigEndian.AppendUint32(report, a.received)
+			report = binary.BigEndian.AppendUint32(report, a.lastSendTime)
+			// how long the echoed packet was held before this report
+			report = binary.BigEndian.AppendUint32(report, uint32(time.Since(a.lastArrival).Microseconds()))
+			report = binary.BigEndian.AppendUint32(report, a.bytes)
+			report = binary.BigEndian.AppendUint32(report, math.Float32bits(float32(a.trend.gradient())))
+			a.mu.Unlock()
+			s.send(report)
+		}
+	}
+}
+
+func (s *session) receiveFeedback(report []byte) {
+	if len(report) < 25 {
+		return
+	}
+	highest := binary.BigEndian.Uint32(report[1:])
+	received := binary.BigEndian.Uint32(report[5:])
+	echo := binary.BigEndian.Uint32(report[9:])
+	hold := binary.BigEndian.Uint32(report[13:])
+	bytes := binary.BigEndian.Uint32(report[17:])
+	gradient := math.Float32frombits(binary.BigEndian.Uint32(report[21:]))
+	now := time.Now()
+
+	cc := &s.cc
+	cc.mu.Lock()
+	if cc.reported && !seqLess(cc.lastHighest, highest) {
+		cc.mu.Unlock()
+		return // nothing new, or reordered
+	}
+	fb := Feedback{Expected: int(highest - cc.lastHighest), DelayGradient: float64(gradient)}
+	if !cc.reported {
+		fb.Expected = int(highest) + 1
+	}
+	got := int(received - cc.lastReceived)
+	fb.Lost = max(fb.Expected-got, 0)
+	if cc.reported {
+		fb.ReceiveRate = int(float64(bytes-cc.lastBytes) / now.Sub(cc.lastReport).Seconds())
+	}
+	cc.lastHighest, cc.lastReceived, cc.lastBytes, cc.lastReport, cc.reported = highest, received, bytes, now, true
+	if rtt := int64(s.clock()-echo) - int64(hold); rtt >= 0 {
+		fb.RTT = time.Duration(rtt) * time.Microsecond
+	} else {
+		fb.RTT = cc.feedback.RTT
+	}
+	cc.feedback = fb
+	cc.mu.Unlock()
+
+	if cc.controller != nil {
+		cc.controller.OnFeedback(fb)
+	}
+	s.estimate(fb)
+}
+
+// SendRate is the rate in bytes per second the congestion controller
+// allows this connection to send at. Applications can use it to adapt
+// their tick rate or quality. 0 without congestion control.
+func (s *session) SendRate() int {
+	if s.cc.controller == nil {
+		return 0
+	}
+	return s.cc.controller.SendRate()
+}
@@ -2,22 +2,18 @@ package client
 
 import (
 	"net"
-
-	"github.com/google/uuid"
-	"github.com/pion/ice/v4"
 )
 
 // Conn implements [net.PacketConn] as well as [net.Conn]
 // Although the message reliability depends on configuration.
-// By default it's UDP hence it's unreliable
+// By default it's UDP hence it's unreliable, use [Conn.WriteReliable]
+// for messages that must arrive.
+// Separate streams of traffic can be multiplexed with [Conn.Channel].
+//
+// A Conn stays the same for the lifetime of a player session,
+// when the guest reconnects it carries on over the new connection.
 type Conn struct {
-	*ice.Conn
-
-	iD uuid.UUID
-}
-
-func newPacketConn(ID uuid.UUID, conn *ice.Conn) Conn {
-	return Conn{iD: ID, Conn: conn}
+	*session
 }
 
 func (conn Conn) ReadFrom(p []byte) (int, net.Addr, error) {
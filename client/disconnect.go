@@ -0,0 +1,127 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DisconnectReason is why a guest went away, see [Owner.OnDisconnect].
+type DisconnectReason uint8
+
+const (
+	// the connection was closed on this side
+	DisconnectClosed DisconnectReason = iota + 1
+	// the owner kicked the guest
+	DisconnectKicked
+	// ice could not keep a path to the guest, and it did not come back
+	DisconnectICEFailed
+	// the guest lost its connection to the signaling server, and did not come back
+	DisconnectSignalingLost
+	// the guest closed its connection
+	DisconnectRemoteClosed
+	// nothing arrived from the guest for [Config.IdleTimeout]
+	DisconnectIdleTimeout
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectClosed:
+		return "closed"
+	case DisconnectKicked:
+		return "kicked"
+	case DisconnectICEFailed:
+		return "ice failed"
+	case DisconnectSignalingLost:
+		return "signaling lost"
+	case DisconnectRemoteClosed:
+		return "remote closed"
+	case DisconnectIdleTimeout:
+		return "idle timeout"
+	}
+	return "unknown reason"
+}
+
+// remember why the session is going away
+func (s *session) setReason(r DisconnectReason) {
+	s.mu.Lock()
+	s.reason = r
+	s.mu.Unlock()
+}
+
+// forget r, the session recovered from it
+func (s *session) clearReason(r DisconnectReason) {
+	s.mu.Lock()
+	if s.reason == r {
+		s.reason = 0
+	}
+	s.mu.Unlock()
+}
+
+// why the closed session went away
+func (s *session) disconnectReason() DisconnectReason {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.reason != 0:
+		return s.reason
+	case s.err == ErrIdleTimeout:
+		return DisconnectIdleTimeout
+	case s.err == ErrSessionExpired:
+		return DisconnectSignalingLost
+	}
+	return DisconnectClosed
+}
+
+// Metadata the guest sent when joining, see [Config.Metadata].
+// It must not be modified.
+func (s *session) Metadata() map[string]string { return s.metadata }
+
+// OnDisconnect calls f once for every guest that goes away for good,
+// after it had time to reconnect. Calling it again replaces f.
+func (owner *Owner) OnDisconnect(f func(conn Conn, reason DisconnectReason)) {
+	owner.connMu.Lock()
+	defer owner.connMu.Unlock()
+	owner.onDisconnect = f
+}
+
+// Peer is a guest connected to the room
+type Peer struct {
+	// stays the same when the guest reconnects, same as [Conn.ID]
+	ID       uuid.UUID
+	Conn     Conn
+	Metadata map[string]string
+	Stats    Stats
+	// false while waiting for the guest to reconnect
+	Connected bool
+	// when the guest joined
+	Joined time.Time
+}
+
+// Peers returns the guests in the room in the order they joined, including the ones that lost
+// their connection and have [Config.ResumeTimeout] left to come back.
+func (owner *Owner) Peers() []Peer {
+	owner.connMu.Lock()
+	sessions := make([]*session, 0, len(owner.sessions))
+	for _, s := range owner.sessions {
+		sessions = append(sessions, s)
+	}
+	owner.connMu.Unlock()
+	peers := make([]Peer, 0, len(sessions))
+	for _, s := range sessions {
+		s.mu.Lock()
+		connected := s.transport != nil
+		s.mu.Unlock()
+		peers = append(peers, Peer{
+			ID:        s.id,
+			Conn:      Conn{s},
+			Metadata:  s.metadata,
+			Stats:     s.Stats(),
+			Connected: connected,
+			Joined:    s.epoch,
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Joined.Before(peers[j].Joined) })
+	return peers
+}
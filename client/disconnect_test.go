@@ -0,0 +1,35 @@
+package client
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		reason DisconnectReason
+		want   DisconnectReason
+	}{
+		{net.ErrClosed, 0, DisconnectClosed},
+		{ErrIdleTimeout, 0, DisconnectIdleTimeout},
+		{ErrSessionExpired, 0, DisconnectSignalingLost},
+		{ErrSessionExpired, DisconnectICEFailed, DisconnectICEFailed},
+		{net.ErrClosed, DisconnectKicked, DisconnectKicked},
+	} {
+		s := &session{err: test.err, reason: test.reason}
+		if got := s.disconnectReason(); got != test.want {
+			t.Errorf("%v after %v: got %v, want %v", test.err, test.reason, got, test.want)
+		}
+	}
+	s := &session{}
+	s.setReason(DisconnectICEFailed)
+	s.clearReason(DisconnectSignalingLost)
+	if s.reason != DisconnectICEFailed {
+		t.Error("cleared the wrong reason")
+	}
+	s.clearReason(DisconnectICEFailed)
+	if s.reason != 0 {
+		t.Error("recovered session keeps its reason")
+	}
+}
@@ -0,0 +1,23 @@
+package client
+
+import "errors"
+
+var (
+	// returned by [Conn.Read] when the guest did not reconnect in time
+	ErrSessionExpired = errors.New("session expired before the guest reconnected")
+	// returned when writing a message larger than [Config.MaxMessageSize]
+	ErrMessageTooLarge = errors.New("message too large")
+	// returned by [TypedConn] for values whose type is not in its [Registry]
+	ErrUnregisteredType = errors.New("type is not registered")
+	// returned by [Subscription.ReadMessage] after the subscription is closed
+	ErrUnsubscribed = errors.New("unsubscribed from topic")
+	// returned by [Conn.Read] when nothing arrived from the remote for [Config.IdleTimeout]
+	ErrIdleTimeout = errors.New("connection idle for too long")
+	// returned by [Conn.Read] after the remote peer closed the connection
+	ErrRemoteClosed = errors.New("connection closed by the remote peer")
+	// returned by [Conn.Read] on a guest the owner kicked.
+	// the error returned wraps it with the reason the owner gave, see [Owner.KickWithReason]
+	ErrKicked = errors.New("kicked by the owner")
+	// returned by [Conn.Read] on a guest after the owner closed the room
+	ErrRoomClosed = errors.New("room closed by the owner")
+)
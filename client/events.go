@@ -0,0 +1,104 @@
+package client
+
+import (
+	"github.com/google/uuid"
+	"github.com/pion/ice/v4"
+)
+
+// EventType is what happened to a connection
+type EventType uint8
+
+const (
+	// a guest asked to join the room, or to resume its session
+	EventJoinRequest EventType = iota + 1
+	// ice is checking candidate pairs
+	EventChecking
+	// ice found a working candidate pair
+	EventConnected
+	// ice selected a different candidate pair
+	EventPairChanged
+	// the connection was lost, ice is trying to restore it
+	EventDisconnected
+	// ice gave up on the connection. the guest restarts ice, or reconnects
+	EventFailed
+	// the owner kicked the guest, see Event.Cause
+	EventKicked
+	// the connection is closed for good, see Event.Err
+	EventClosed
+	// the owner closed the room, on the guest
+	EventRoomClosed
+	// the owner lost its connection to the signaling server, see Event.Err.
+	// sessions carry on over ice, but guests can't join or reconnect anymore
+	EventSignalingLost
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoinRequest:
+		return "join request"
+	case EventChecking:
+		return "checking"
+	case EventConnected:
+		return "connected"
+	case EventPairChanged:
+		return "pair changed"
+	case EventDisconnected:
+		return "disconnected"
+	case EventFailed:
+		return "failed"
+	case EventKicked:
+		return "kicked"
+	case EventClosed:
+		return "closed"
+	case EventRoomClosed:
+		return "room closed"
+	case EventSignalingLost:
+		return "signaling lost"
+	}
+	return "unknown event"
+}
+
+// Event in the lifecycle of a connection, see [Config.OnEvent].
+type Event struct {
+	Type EventType
+	// [Conn.ID] of the guest the event is about.
+	// it stays the same when the guest reconnects.
+	// zero for EventSignalingLost, which is about the room
+	Peer uuid.UUID
+	// the newly selected candidate pair, for EventPairChanged
+	Local, Remote CandidateStats
+	// why the connection closed, for EventClosed and EventSignalingLost
+	Err error
+	// why the guest went away, for EventClosed on the owner
+	Reason DisconnectReason
+	// the reason the owner gave, for EventKicked
+	Cause string
+}
+
+// the selected candidate pair changed
+type pairChange struct {
+	local, remote CandidateStats
+}
+
+func (cfg Config) emit(e Event) {
+	if cfg.OnEvent != nil {
+		cfg.OnEvent(e)
+	}
+}
+
+// event for an ice connection state, false for states that aren't reported
+func stateEvent(cs ice.ConnectionState) (EventType, bool) {
+	switch cs {
+	case ice.ConnectionStateChecking:
+		return EventChecking, true
+	case ice.ConnectionStateConnected:
+		return EventConnected, true
+	case ice.ConnectionStateDisconnected:
+		return EventDisconnected, true
+	case ice.ConnectionStateFailed:
+		return EventFailed, true
+	}
+	// closed agents are replaced when the guest reconnects,
+	// EventClosed is sent when the session closes instead
+	return 0, false
+}
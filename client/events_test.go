@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrownNPC/Ice-Data-Channel/server"
+)
+
+// events reported to one side, in order
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) add(e Event) {
+	l.mu.Lock()
+	l.events = append(l.events, e)
+	l.mu.Unlock()
+}
+
+// types of the events so far, without pair changes which come at any time
+func (l *eventLog) types() []EventType {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var types []EventType
+	for _, e := range l.events {
+		if e.Type != EventPairChanged {
+			types = append(types, e.Type)
+		}
+	}
+	return types
+}
+
+// wait until the last event reported is typ
+func (l *eventLog) waitFor(t *testing.T, typ EventType) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if types := l.types(); len(types) > 0 && types[len(types)-1] == typ {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %v event, got %v", typ, l.types())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// a guest joined to a room on a local signaling server, over ice on localhost.
+// ownerCfg and guestCfg adjust the config of each side
+func joinRoom(t *testing.T, ownerCfg, guestCfg func(*Config)) (*Owner, *Guest, Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go server.Serve(l, "/ws")
+	cfg := DefaultConfig(l.Addr().String(), "/ws")
+	cfg.AgentCfg.Urls = nil
+	oc, gc := cfg, cfg
+	if ownerCfg != nil {
+		ownerCfg(&oc)
+	}
+	if guestCfg != nil {
+		guestCfg(&gc)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	conns := make(chan Conn, 1)
+	owner, err := NewOwner(ctx, func(c Conn) { conns <- c }, oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { owner.Close() })
+	guest, err := NewGuest(ctx, owner.RoomID, gc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return owner, guest, <-conns
+}
+
+func TestEventSequence(t *testing.T) {
+	var ownerLog, guestLog eventLog
+	owner, guest, conn := joinRoom(t,
+		func(c *Config) { c.OnEvent = ownerLog.add },
+		func(c *Config) { c.OnEvent = guestLog.add })
+	guestLog.waitFor(t, EventConnected)
+	ownerLog.waitFor(t, EventConnected)
+	if stats := conn.Stats(); stats.LocalCandidate.Type == 0 || stats.RemoteCandidate.Port == 0 {
+		t.Errorf("no selected candidate pair in %+v", stats)
+	}
+
+	owner.Close()
+	guestLog.waitFor(t, EventClosed)
+	ownerLog.waitFor(t, EventClosed)
+	want := []EventType{EventJoinRequest, EventChecking, EventConnected, EventClosed}
+	if got := ownerLog.types(); !slices.Equal(got, want) {
+		t.Errorf("owner got %v, want %v", got, want)
+	}
+	want = []EventType{EventJoinRequest, EventChecking, EventConnected, EventRoomClosed, EventClosed}
+	if got := guestLog.types(); !slices.Equal(got, want) {
+		t.Errorf("guest got %v, want %v", got, want)
+	}
+	for _, log := range []*eventLog{&ownerLog, &guestLog} {
+		for _, e := range log.events {
+			if e.Peer != guest.Conn().ID() {
+				t.Errorf("%v event is about %v, not the guest", e.Type, e.Peer)
+			}
+		}
+	}
+}
+
+func TestSignalingLost(t *testing.T) {
+	var ownerLog eventLog
+	owner, guest, conn := joinRoom(t, func(c *Config) { c.OnEvent = ownerLog.add }, nil)
+	ownerLog.waitFor(t, EventConnected)
+
+	owner.ws.CloseNow()
+	ownerLog.waitFor(t, EventSignalingLost)
+	// the session carries on without the signaling server
+	if _, err := conn.WriteReliable([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	guest.Conn().SetReadDeadline(time.Now().Add(10 * time.Second))
+	p := make([]byte, 64)
+	if n, err := guest.Conn().Read(p); err != nil || string(p[:n]) != "still here" {
+		t.Errorf("got %q, %v", p[:n], err)
+	}
+}
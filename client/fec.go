@@ -0,0 +1,235 @@
+package client
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// forward error correction with xor parity.
+// unreliable packets of a channel are sent in groups, after every group
+// a parity packet holding the xor of their bodies is sent.
+// the receiver rebuilds any one lost packet of a group from the others
+// and the parity, without waiting for a retransmission.
+const (
+	// protected packets are followed by a uint16 group and uint8 index
+	fecHeaderSize = 1 + 1 + 2 + 1
+	// parity packets carry the group size and the xor of the body lengths instead
+	parityHeaderSize = 1 + 1 + 2 + 1 + 2
+	// largest group, a parity packet per this many packets
+	maxFECGroupSize = 64
+	// incomplete groups are sent parity for after this long,
+	// so the last packets of a burst are protected too
+	fecFlushTimeout = retransmitTimeout / 4
+	// groups the receiver keeps packets of to rebuild lost ones
+	maxFECGroups    = 64
+	fecGroupTimeout = time.Second
+)
+
+// sender side
+type fecEncoder struct {
+	// packets per parity packet, 0 when fec is off
+	size    int
+	group   uint16
+	count   int
+	parity  []byte
+	lengths uint16
+	started time.Time
+}
+
+// receiver side
+type fecGroup struct {
+	bodies [][]byte
+	// got[i] is set once body i was delivered, received or rebuilt
+	got      []bool
+	received int
+	// -1 until the parity packet arrives
+	count   int
+	parity  []byte
+	lengths uint16
+	started time.Time
+}
+
+// SetFEC protects the unreliable messages written to the channel with
+// forward error correction. A parity packet is sent for every groupSize
+// packets, so the remote can rebuild one lost packet per group without a
+// retransmission, at the cost of 1/groupSize more traffic.
+// Smaller groups recover more loss. 0 turns it off.
+// Reliable messages are not protected, they are retransmitted instead.
+func (ch *Channel) SetFEC(groupSize int) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.fec = fecEncoder{size: min(max(groupSize, 0), maxFECGroupSize), group: ch.fec.group + 1}
+}
+
+// SetFEC protects the unreliable messages written with Write,
+// see [Channel.SetFEC].
+func (s *session) SetFEC(groupSize int) {
+	s.main.SetFEC(groupSize)
+}
+
+// headers in front of a message body, so it still fits a datagram
+func (ch *Channel) headerSize() int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.fec.size > 0 {
+		return max(packetHeaderSize, parityHeaderSize)
+	}
+	return packetHeaderSize
+}
+
+// packets to send for an unreliable body, including the parity when it completes a group.
+// must be called with ch.mu held.
+func (ch *Channel) protect(body []byte) [][]byte {
+	e := &ch.fec
+	if e.size == 0 {
+		return [][]byte{append([]byte{kindUnreliable, ch.id}, body...)}
+	}
+	if e.count == 0 {
+		e.started = time.Now()
+	}
+	packet := binary.BigEndian.AppendUint16([]byte{kindProtected, ch.id}, e.group)
+	packet = append(packet, byte(e.count))
+	packet = append(packet, body...)
+
+	if len(body) > len(e.parity) {
+		e.parity = append(e.parity, make([]byte, len(body)-len(e.parity))...)
+	}
+	for i, b := range body {
+		e.parity[i] ^= b
+	}
+	e.lengths ^= uint16(len(body))
+	e.count++
+	if e.count < e.size {
+		return [][]byte{packet}
+	}
+	return [][]byte{packet, ch.parityPacket()}
+}
+
+// finish the current group. must be called with ch.mu held.
+func (ch *Channel) parityPacket() []byte {
+	e := &ch.fec
+	packet := binary.BigEndian.AppendUint16([]byte{kindParity, ch.id}, e.group)
+	packet = append(packet, byte(e.count))
+	packet = binary.BigEndian.AppendUint16(packet, e.lengths)
+	packet = append(packet, e.parity...)
+	e.group++
+	e.count = 0
+	e.parity = e.parity[:0]
+	e.lengths = 0
+	return packet
+}
+
+// parity of a group that has been waiting too long for more packets
+func (ch *Channel) flushFEC(now time.Time) []byte {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.fec.count == 0 || now.Sub(ch.fec.started) < fecFlushTimeout {
+		return nil
+	}
+	return ch.parityPacket()
+}
+
+// get the receive state of a group, dropping the oldest ones
+func (ch *Channel) fecGroup(id uint16) *fecGroup {
+	g, ok := ch.fecGroups[id]
+	if ok {
+		return g
+	}
+	now := time.Now()
+	var oldest *fecGroup
+	var oldestID uint16
+	for gid, g := range ch.fecGroups {
+		if now.Sub(g.started) > fecGroupTimeout {
+			delete(ch.fecGroups, gid)
+		} else if oldest == nil || g.started.Before(oldest.started) {
+			oldest, oldestID = g, gid
+		}
+	}
+	if len(ch.fecGroups) >= maxFECGroups {
+		delete(ch.fecGroups, oldestID)
+	}
+	g = &fecGroup{count: -1, started: now}
+	ch.fecGroups[id] = g
+	return g
+}
+
+// grow the per packet state of a group to hold index i
+func (g *fecGroup) grow(i int) {
+	for len(g.got) <= i {
+		g.got = append(g.got, false)
+		g.bodies = append(g.bodies, nil)
+	}
+}
+
+func (ch *Channel) receiveProtected(packet []byte) {
+	if len(packet) < 3 {
+		return
+	}
+	id, i, body := binary.BigEndian.Uint16(packet), int(packet[2]), packet[3:]
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	g := ch.fecGroup(id)
+	g.grow(i)
+	if g.got[i] {
+		return // already rebuilt
+	}
+	g.got[i] = true
+	g.bodies[i] = clone(body)
+	g.received++
+	ch.deliver(body, false)
+	ch.recover(g)
+}
+
+func (ch *Channel) receiveParity(packet []byte) {
+	if len(packet) < 5 {
+		return
+	}
+	id, count := binary.BigEndian.Uint16(packet), int(packet[2])
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	g := ch.fecGroup(id)
+	if g.count >= 0 || count == 0 {
+		return
+	}
+	g.count = count
+	g.lengths = binary.BigEndian.Uint16(packet[3:])
+	g.parity = clone(packet[5:])
+	g.grow(count - 1)
+	ch.recover(g)
+}
+
+// rebuild the packet of a group that was lost, if it is the only one.
+// must be called with ch.mu held.
+func (ch *Channel) recover(g *fecGroup) {
+	if g.count < 0 || g.received != g.count-1 {
+		return
+	}
+	missing := -1
+	for i := range g.count {
+		if !g.got[i] {
+			missing = i
+			break
+		}
+	}
+	if missing < 0 {
+		return
+	}
+	body := clone(g.parity)
+	length := g.lengths
+	for i, b := range g.bodies[:g.count] {
+		if i == missing {
+			continue
+		}
+		length ^= uint16(len(b))
+		for j := range min(len(b), len(body)) {
+			body[j] ^= b[j]
+		}
+	}
+	if int(length) > len(body) {
+		return // corrupt
+	}
+	g.got[missing] = true
+	g.received++
+	ch.s.fecRecovered.Add(1)
+	ch.deliver(body[:length], false)
+}
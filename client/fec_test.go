@@ -0,0 +1,48 @@
+package client
+
+import "testing"
+
+func TestFECRecover(t *testing.T) {
+	s := &session{}
+	sender := newChannel(s, 1, Unreliable)
+	sender.SetFEC(4)
+	receiver := newChannel(s, 1, Unreliable)
+
+	var packets [][]byte
+	msgs := [][]byte{[]byte("a"), []byte("longer message"), []byte("abc"), []byte("0123456789")}
+	for _, msg := range msgs {
+		packets = append(packets, sender.protect(append([]byte{0}, msg...))...)
+	}
+	if len(packets) != len(msgs)+1 || packets[len(packets)-1][0] != kindParity {
+		t.Fatalf("expected %d packets ending in parity, got %d", len(msgs)+1, len(packets))
+	}
+	// lose the second packet, and deliver the parity before the rest
+	lost := packets[1]
+	for _, packet := range [][]byte{packets[0], packets[4], packets[2], packets[3]} {
+		switch packet[0] {
+		case kindProtected:
+			receiver.receiveProtected(packet[2:])
+		case kindParity:
+			receiver.receiveParity(packet[2:])
+		}
+	}
+	// the lost packet shows up after it was rebuilt
+	receiver.receiveProtected(lost[2:])
+
+	got := map[string]bool{}
+	for len(receiver.incoming) > 0 {
+		msg := <-receiver.incoming
+		if got[string(msg)] {
+			t.Errorf("%q delivered twice", msg)
+		}
+		got[string(msg)] = true
+	}
+	for _, msg := range msgs {
+		if !got[string(msg)] {
+			t.Errorf("%q was not delivered", msg)
+		}
+	}
+	if n := s.fecRecovered.Load(); n != 1 {
+		t.Errorf("recovered %d packets, expected 1", n)
+	}
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	// conservative datagram size that makes it through most paths
+	// without being fragmented by ip, used until path mtu discovery finds a larger one
+	defaultDatagramSize = 1200
+	// kind, channel and sequence number of a data packet
+	packetHeaderSize = 1 + 1 + 4
+	// flags and fragment header at the start of a message body
+	bodyHeaderSize = 1 + fragmentHeaderSize
+	// message id, fragment index and fragment count
+	fragmentHeaderSize = 4 + 2 + 2
+
+	// most messages being reassembled on one channel at once
+	maxReassemblies = 64
+)
+
+// flags at the start of every message body
+const (
+	flagFragment byte = 1 << iota
+	flagCompressed
+)
+
+// a message that is split into fragments,
+// the receiver puts it back together before Read returns it.
+type reassembly struct {
+	fragments [][]byte
+	received  int
+	size      int
+	started   time.Time
+	// reliable messages are never given up on,
+	// all fragments are retransmitted until they arrive
+	reliable bool
+}
+
+// split a message into bodies that each fit in one datagram
+func fragment(msgID uint32, p []byte, maxBody int, flags byte) [][]byte {
+	if len(p)+1 <= maxBody {
+		return [][]byte{append([]byte{flags}, p...)}
+	}
+	chunk := maxBody - bodyHeaderSize
+	count := (len(p) + chunk - 1) / chunk
+	bodies := make([][]byte, 0, count)
+	for i := range count {
+		data := p[i*chunk : min((i+1)*chunk, len(p))]
+		body := make([]byte, 0, bodyHeaderSize+len(data))
+		body = append(body, flags|flagFragment)
+		body = binary.BigEndian.AppendUint32(body, msgID)
+		body = binary.BigEndian.AppendUint16(body, uint16(i))
+		body = binary.BigEndian.AppendUint16(body, uint16(count))
+		bodies = append(bodies, append(body, data...))
+	}
+	return bodies
+}
+
+// most fragments a message may be split into,
+// the fragment header can't count higher
+const maxFragments = 1<<16 - 1
+
+// add a fragment to the message it belongs to.
+// once every fragment arrived the whole message is handed to accept,
+// if accept refuses it the last fragment is forgotten, so it can be retried.
+// returns false if the fragment should be retried later,
+// broken fragments are dropped and count as handled.
+// must be called with ch.mu held.
+func (ch *Channel) reassemble(body []byte, reliable bool, accept func(msg []byte) bool) bool {
+	if len(body) < fragmentHeaderSize {
+		return true
+	}
+	msgID := binary.BigEndian.Uint32(body)
+	index := int(binary.BigEndian.Uint16(body[4:]))
+	count := int(binary.BigEndian.Uint16(body[6:]))
+	data := body[fragmentHeaderSize:]
+	if count == 0 || index >= count {
+		return true
+	}
+
+	now := time.Now()
+	r, ok := ch.reassemblies[msgID]
+	if !ok {
+		ch.expireReassemblies(now)
+		if len(ch.reassemblies) >= maxReassemblies {
+			return false
+		}
+		r = &reassembly{
+			fragments: make([][]byte, count),
+			started:   now,
+			reliable:  reliable,
+		}
+		ch.reassemblies[msgID] = r
+	}
+	if len(r.fragments) != count {
+		return true // corrupt
+	}
+	if r.fragments[index] != nil {
+		return true // duplicate
+	}
+	if r.size+len(data) > ch.s.cfg.maxMessageSize() {
+		delete(ch.reassemblies, msgID)
+		return true
+	}
+	if r.received+1 < count {
+		r.fragments[index] = clone(data)
+		r.size += len(data)
+		r.received++
+		return true
+	}
+
+	msg := make([]byte, 0, r.size+len(data))
+	for i, f := range r.fragments {
+		if i == index {
+			f = data
+		}
+		msg = append(msg, f...)
+	}
+	if !accept(msg) {
+		return false
+	}
+	delete(ch.reassemblies, msgID)
+	return true
+}
+
+// drop unreliable messages that are missing fragments for too long
+func (ch *Channel) expireReassemblies(now time.Time) {
+	timeout := ch.s.cfg.reassemblyTimeout()
+	for id, r := range ch.reassemblies {
+		if !r.reliable && now.Sub(r.started) > timeout {
+			delete(ch.reassemblies, id)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFragmentReassemble(t *testing.T) {
+	msg := make([]byte, 10000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	bodies := fragment(7, msg, 1000, 0)
+	if len(bodies) < 2 {
+		t.Fatal("message was not split")
+	}
+	ch := newChannel(&session{}, 0, Unreliable)
+	// deliver out of order, with a duplicate
+	bodies = append(bodies, bodies[0])
+	for i := len(bodies) - 1; i >= 0; i-- {
+		if len(bodies[i]) > 1000 {
+			t.Error("fragment larger than datagram")
+		}
+		ch.deliver(bodies[i], false)
+	}
+	select {
+	case got := <-ch.incoming:
+		if !bytes.Equal(got, msg) {
+			t.Error("reassembled message differs")
+		}
+	default:
+		t.Fatal("message was not reassembled")
+	}
+	if len(ch.incoming) != 0 {
+		t.Error("duplicate fragment delivered message twice")
+	}
+}
+
+func TestFragmentLimits(t *testing.T) {
+	ch := newChannel(&session{cfg: Config{MaxMessageSize: 2000, ReassemblyTimeout: time.Millisecond}}, 0, Unreliable)
+	for _, body := range fragment(1, make([]byte, 5000), 1000, 0) {
+		ch.deliver(body, false)
+	}
+	if len(ch.incoming) != 0 {
+		t.Error("message larger than MaxMessageSize was delivered")
+	}
+	// one fragment missing, the message expires
+	bodies := fragment(2, make([]byte, 1500), 1000, 0)
+	ch.deliver(bodies[0], false)
+	time.Sleep(time.Millisecond * 5)
+	ch.deliver(fragment(3, make([]byte, 1500), 1000, 0)[0], false)
+	if _, ok := ch.reassemblies[2]; ok {
+		t.Error("incomplete message did not expire")
+	}
+}
@@ -2,8 +2,13 @@ package client
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/BrownNPC/Ice-Data-Channel/message"
 	"github.com/google/uuid"
@@ -13,92 +18,408 @@ import (
 )
 
 type Guest struct {
+	// replaced when the guest reconnects
 	pc   *peerConnection
 	ws   ws
+	mu   sync.Mutex
 	conn Conn
+
+	roomID    string
+	cfg       Config
+	sessionID uuid.UUID
+	// sent with every join, so only this guest can resume the session
+	secret string
+	// nil without compression, and what the owner agreed to
+	compressor  *compressor
+	compression *compressor
+
+	// subscriptions by topic
+	topics     map[string][]*Subscription
+	topicsMu   sync.Mutex
+	topicsOnce sync.Once
+
+	// set while waiting for the owner to answer an ice restart
+	restarting atomic.Bool
+	// set while the guest is reconnecting to the room
+	reconnecting atomic.Bool
+	// why NewGuest fails, if the owner ended the session while joining. guarded by mu
+	joinErr error
+	// stops the goroutines serving the guest once the session is over
+	cancel      context.CancelFunc
+	releaseOnce sync.Once
 }
 
 func NewGuest(ctx context.Context, roomID string, cfg Config) (guest *Guest, err error) {
-	conn, _, err := websocket.Dial(ctx, cfg.SignalingServer.String(), nil)
+	compressor, err := newCompressor(cfg)
 	if err != nil {
-		return
+		return nil, err
+	}
+	guest = &Guest{
+		roomID:     roomID,
+		cfg:        cfg,
+		sessionID:  uuid.New(),
+		secret:     rand.Text(),
+		compressor: compressor,
+		topics:     map[string][]*Subscription{},
+	}
+	ctx, guest.cancel = context.WithCancel(ctx)
+	pc, ice_conn, err := guest.connect(ctx, ctx, false)
+	guest.mu.Lock()
+	// kicked or the room closed while joining
+	if guest.joinErr != nil {
+		err = guest.joinErr
+		if ice_conn != nil {
+			ice_conn.Close()
+			pc.Close()
+		}
 	}
-	pc, ufrag, pwd, err := newPeerConnection(cfg.AgentCfg)
+	if err == nil {
+		s := newSession(guest.sessionID, uuid.UUID{}, pc, ice_conn, guest.cfg, guest.compression)
+		s.metadata = cfg.Metadata
+		guest.conn = Conn{s}
+	}
+	guest.mu.Unlock()
+	if err != nil {
+		guest.cancel()
+		guest.compressor.close()
+		return nil, err
+	}
+	go guest.conn.syncClock()
+	go func() {
+		<-guest.conn.Done()
+		guest.release()
+		err := guest.conn.err
+		switch {
+		case errors.Is(err, ErrKicked):
+			guest.cfg.emit(Event{Type: EventKicked, Peer: guest.sessionID, Cause: kickReason(err)})
+		case err == ErrRoomClosed:
+			guest.cfg.emit(Event{Type: EventRoomClosed, Peer: guest.sessionID})
+		}
+		guest.cfg.emit(Event{Type: EventClosed, Peer: guest.sessionID, Err: err})
+	}()
+	return
+}
+
+// join the room and connect to the owner.
+// ctx bounds the lifetime of the signaling connection, dialCtx only the handshake.
+// resume asks the owner for the guest's existing session.
+func (guest *Guest) connect(ctx, dialCtx context.Context, resume bool) (pc *peerConnection, ice_conn *ice.Conn, err error) {
+	conn, _, err := websocket.Dial(dialCtx, guest.cfg.SignalingServer.String(), nil)
 	if err != nil {
 		return
 	}
-	guest = &Guest{
-		ws: ws{conn},
-		pc: pc,
+	pc, ufrag, pwd, err := newPeerConnection(guest.cfg.AgentCfg)
+	defer func() {
+		if err != nil {
+			conn.Close(websocket.StatusNormalClosure, "failed to connect")
+			if pc != nil {
+				pc.Close()
+			}
+		}
+	}()
+	if err != nil {
+		return
 	}
-	err = guest.ws.WriteMsg(ctx, message.JoinRoomRequestMsg(roomID))
+	ws := ws{conn}
+	guest.mu.Lock()
+	guest.ws, guest.pc = ws, pc
+	guest.mu.Unlock()
+	guest.restarting.Store(false)
+
+	err = ws.WriteMsg(dialCtx, message.JoinRoomRequestMsg(guest.roomID))
 	if err != nil {
 		return
 	}
+	guest.cfg.emit(Event{Type: EventJoinRequest, Peer: guest.sessionID})
 	// initiate ice auth
-	err = guest.ws.WriteMsg(ctx, message.IceAuthInitiateMsg(ufrag, pwd))
+	err = ws.WriteMsg(dialCtx, message.IceAuthInitiateMsg(ufrag, pwd, guest.sessionID, resume, guest.secret, guest.compressor.offer(), guest.cfg.Metadata))
 	if err != nil {
 		return
 	}
 	// wait for response
-	msg, err := guest.ws.ReadMsg(ctx)
+	msg, err := ws.ReadMsg(dialCtx)
 	if err != nil {
 		return
 	}
+	switch msg.Type {
+	case message.RoomClosed:
+		err = ErrRoomClosed
+		return
+	case message.Kick:
+		err = kickedError{msg.KickReason}
+		return
+	case message.ResumeRejected:
+		err = ErrSessionExpired
+		return
+	}
 	if msg.Type != message.IceAuthResponse {
-		guest.ws.Close(websocket.StatusProtocolError, "wrong message type sent. expected IceAuthResponse")
-		return nil, fmt.Errorf("invalid response type from owner %s", msg.Type)
+		ws.Close(websocket.StatusProtocolError, "wrong message type sent. expected IceAuthResponse")
+		err = fmt.Errorf("invalid response type from owner %s", msg.Type)
+		return
 	}
 	remoteUfrag, remotePwd := msg.Ufrag, msg.Pwd
-	go guest.CandidateListener(ctx)
+	// the session keeps what was agreed on when it was created
+	guest.compression = guest.compressor.negotiate(msg.Compression)
+	go guest.listen(ctx, ws, pc)
+	go guest.forwardCandidates(ctx, ws, pc)
+	go guest.watchConnectionState(ctx, pc)
 
-	ice_conn, err := guest.pc.Accept(ctx, remoteUfrag, remotePwd)
-	if err != nil {
-		return
-	}
-	guest.conn = newPacketConn(uuid.UUID{}, ice_conn)
+	ice_conn, err = pc.Accept(dialCtx, remoteUfrag, remotePwd)
 	return
 }
+
 func (guest *Guest) Conn() Conn { return guest.conn }
 
-// listen for ice candidates over websocket
+// the guest's session, nil while it is joining the room
+func (guest *Guest) session() *session {
+	guest.mu.Lock()
+	defer guest.mu.Unlock()
+	return guest.conn.session
+}
+
+// the owner ended the session with err.
+// while the guest is still joining, NewGuest fails with err instead
+func (guest *Guest) end(err error, reason DisconnectReason) {
+	guest.mu.Lock()
+	s := guest.conn.session
+	if s == nil {
+		guest.joinErr = err
+	}
+	guest.mu.Unlock()
+	if s == nil {
+		guest.cancel()
+		return
+	}
+	s.setReason(reason)
+	s.close(err)
+}
+
+// the signaling connection and peer connection currently in use
+func (guest *Guest) current() (ws, *peerConnection) {
+	guest.mu.Lock()
+	defer guest.mu.Unlock()
+	return guest.ws, guest.pc
+}
+
+// CandidateListener waits until ctx is done or the session is over.
+//
+// Deprecated: the guest listens for signaling messages on its own
+// for as long as the session lasts.
 func (guest *Guest) CandidateListener(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-guest.conn.Done():
+	}
+}
+
+// listen for signaling messages over websocket.
+// keeps listening after ice connects, so the connection can be restarted,
+// and the owner can kick the guest or close the room.
+func (guest *Guest) listen(ctx context.Context, ws ws, pc *peerConnection) {
 	for {
-		select {
-		case <-ctx.Done():
+		msg, err := ws.ReadMsg(ctx)
+		// the guest left or its session is over
+		if ctx.Err() != nil {
 			return
-		case cs := <-guest.pc.connectionState:
-			switch cs {
-			case ice.ConnectionStateConnected,
-				ice.ConnectionStateCompleted,
-				ice.ConnectionStateFailed,
-				ice.ConnectionStateDisconnected,
-				ice.ConnectionStateClosed:
-				slog.Info("stopping listening for candidates", "state", cs.String())
-				return // stop
+		}
+		if err != nil {
+			slog.Error("failed to read message", "error", err)
+			ws.Close(websocket.StatusNormalClosure, "failed to read message")
+			guest.signalingLost(ctx, pc)
+			return
+		}
+		switch msg.Type {
+		case message.Ping:
+			continue
+		// the owner's close notice may have been lost
+		case message.RoomClosed:
+			guest.end(ErrRoomClosed, DisconnectRemoteClosed)
+			return
+		case message.Kick:
+			guest.end(kickedError{msg.KickReason}, DisconnectKicked)
+			return
+		// the session expired on the owner while reconnecting
+		case message.ResumeRejected:
+			guest.end(ErrSessionExpired, DisconnectSignalingLost)
+			return
+		case message.IceCandidateForGuest:
+			err = pc.AddRemoteCandidate(msg.Candidate)
+			if err != nil {
+				ws.Close(websocket.StatusProtocolError, "invalid ice candidate received")
+				slog.Error("invalid ice candidate", "error", err)
+				guest.signalingLost(ctx, pc)
+				return
+			}
+		case message.IceRestartResponse:
+			// the owner has no agent to restart, start over with a new one
+			if !msg.Success {
+				slog.Error("owner failed to restart ice", "cause", msg.Cause)
+				ws.Close(websocket.StatusNormalClosure, "ice restart failed")
+				guest.signalingLost(ctx, pc)
+				return
+			}
+			err = pc.Renegotiate(msg.Ufrag, msg.Pwd)
+			guest.restarting.Store(false)
+			if err != nil {
+				slog.Error("failed to renegotiate ice", "error", err)
 			}
 		default:
-			msg, err := guest.ws.ReadMsg(ctx)
+			slog.Error("invalid message type received", "type", msg.Type.String())
+			ws.Close(websocket.StatusProtocolError, "invalid message type received")
+			guest.signalingLost(ctx, pc)
+			return
+		}
+	}
+}
+
+// the owner can not be reached anymore over this signaling connection.
+// reconnect in the background and resume the session.
+func (guest *Guest) signalingLost(ctx context.Context, pc *peerConnection) {
+	if ctx.Err() != nil || guest.session() == nil {
+		return
+	}
+	if _, current := guest.current(); current != pc {
+		return // already replaced
+	}
+	if !guest.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	pc.Close()
+	guest.conn.detach(guest.cfg.ResumeTimeout)
+	go guest.reconnect(ctx)
+}
+
+// try to reconnect until the session expires
+func (guest *Guest) reconnect(ctx context.Context) {
+	backoff := time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-guest.conn.Done():
+			return
+		case <-time.After(backoff):
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, time.Second*15)
+		pc, ice_conn, err := guest.connect(ctx, dialCtx, true)
+		cancel()
+		if err == nil {
+			guest.conn.rebind(uuid.UUID{}, pc, ice_conn)
+			guest.reconnecting.Store(false)
+			return
+		}
+		// the owner gave up on the session, starting over would lose data
+		if errors.Is(err, ErrSessionExpired) {
+			guest.conn.close(ErrSessionExpired)
+			return
+		}
+		slog.Debug("failed to reconnect", "error", err)
+		backoff = min(backoff*2, time.Second*8)
+	}
+}
+
+// forward locally gathered ice candidates to the owner
+func (guest *Guest) forwardCandidates(ctx context.Context, ws ws, pc *peerConnection) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pc.done:
+			return
+		case c := <-pc.localCandidates:
+			err := ws.WriteMsg(ctx, message.IceCandidateForOwnerMsg(c))
 			if err != nil {
-				slog.Error("failed to read message", "error", err)
-				guest.ws.Close(websocket.StatusNormalClosure, "failed to read message")
+				slog.Debug("error sending ice candidate", "error", err)
 				return
 			}
-			if msg.Type == message.Ping {
-				continue
+		}
+	}
+}
+
+// how long a disconnected connection gets to recover on its own
+// before ice is restarted
+const restartGrace = time.Second * 3
+
+// how long to wait for the owner to answer an ice restart before trying again
+const restartTimeout = time.Second * 10
+
+// restart ice when the connection is lost,
+// for example when switching from Wi-Fi to Ethernet.
+func (guest *Guest) watchConnectionState(ctx context.Context, pc *peerConnection) {
+	// fires when the connection stayed disconnected for restartGrace
+	var grace <-chan time.Time
+	// fires when the owner did not answer the last restart in time
+	var answer <-chan time.Time
+	restart := func() {
+		grace = nil
+		sent, err := guest.restartIce(ctx, pc)
+		if err != nil {
+			slog.Error("failed to restart ice", "error", err)
+		}
+		if sent {
+			answer = time.After(restartTimeout)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pc.done:
+			return
+		case <-grace:
+			restart()
+		case <-answer:
+			answer = nil
+			// the request or the response was lost
+			if guest.restarting.CompareAndSwap(true, false) {
+				slog.Debug("ice restart timed out")
+				restart()
 			}
-			if msg.Type != message.IceCandidateForGuest {
-				slog.Error("invalid message type received", "type", msg.Type.String())
-				guest.ws.Close(websocket.StatusProtocolError, "invalid message type received, was expecting ice candidate")
-				return
+		case change := <-pc.pairChanges:
+			guest.cfg.emit(Event{Type: EventPairChanged, Peer: guest.sessionID, Local: change.local, Remote: change.remote})
+		case cs := <-pc.connectionState:
+			slog.Debug("connection state changed", "state", cs.String())
+			if typ, ok := stateEvent(cs); ok {
+				guest.cfg.emit(Event{Type: typ, Peer: guest.sessionID})
 			}
-			err = guest.pc.AddRemoteCandidate(msg.Candidate)
-			if err != nil {
-				guest.ws.Close(websocket.StatusProtocolError, "invalid ice candidate received")
-				slog.Error("invalid ice candidate", "error", err)
+			switch cs {
+			// ice often recovers from this by itself
+			case ice.ConnectionStateDisconnected:
+				if grace == nil {
+					grace = time.After(restartGrace)
+				}
+			case ice.ConnectionStateConnected:
+				grace = nil
+			case ice.ConnectionStateFailed:
+				restart()
+			case ice.ConnectionStateClosed:
 				return
 			}
 		}
 	}
+}
 
+// generate fresh credentials and send them to the owner.
+// gathering starts once the owner responds.
+// sent reports whether the guest is now waiting for the owner to respond.
+func (guest *Guest) restartIce(ctx context.Context, pc *peerConnection) (sent bool, err error) {
+	ws, current := guest.current()
+	if pc != current {
+		return false, nil // replaced by reconnecting
+	}
+	if !guest.restarting.CompareAndSwap(false, true) {
+		return false, nil // already waiting for the owner
+	}
+	ufrag, pwd, err := pc.Restart()
+	if err != nil {
+		guest.restarting.Store(false)
+		return false, err
+	}
+	slog.Info("restarting ice")
+	err = ws.WriteMsg(ctx, message.IceRestartMsg(ufrag, pwd))
+	if err != nil {
+		guest.restarting.Store(false)
+		return false, err
+	}
+	return true, nil
 }
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// keepalive probes. a ping is sent over the peer to peer path every
+// keepalive interval and answered with a pong right away. they measure
+// the round trip time and its jitter even when no data flows, and
+// keep nat bindings open. a remote that goes silent for longer than
+// Config.IdleTimeout closes the session with ErrIdleTimeout.
+// while ice is recovering the connection the remote gets
+// Config.ResumeTimeout instead, if that is longer.
+const (
+	// pings the loss rate is measured over
+	keepaliveWindow = 20
+	// a ping that wasn't answered in time counts as lost
+	pongTimeout = 2 * time.Second
+)
+
+type keepalive struct {
+	mu     sync.Mutex
+	nextID uint32
+	// recent pings, by id modulo the window
+	pings [keepaliveWindow]ping
+	// smoothed round trip time and its variation, like tcp's srtt
+	rtt    time.Duration
+	jitter time.Duration
+	// when anything last arrived from the remote
+	lastSeen time.Time
+}
+
+type ping struct {
+	id       uint32
+	sentAt   time.Time
+	answered bool
+}
+
+// RTT is the smoothed round trip time measured by keepalive probes,
+// 0 before the first one was answered.
+func (s *session) RTT() time.Duration {
+	s.keepalive.mu.Lock()
+	defer s.keepalive.mu.Unlock()
+	return s.keepalive.rtt
+}
+
+// LastSeen is when a packet of any kind last arrived from the remote.
+func (s *session) LastSeen() time.Time {
+	s.keepalive.mu.Lock()
+	defer s.keepalive.mu.Unlock()
+	return s.keepalive.lastSeen
+}
+
+// something arrived from the remote
+func (s *session) seen(now time.Time) {
+	s.keepalive.mu.Lock()
+	s.keepalive.lastSeen = now
+	s.keepalive.mu.Unlock()
+}
+
+func (s *session) keepaliveLoop() {
+	ticker := time.NewTicker(s.cfg.keepaliveInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			attached, pc := s.transport != nil, s.pc
+			s.mu.Unlock()
+			// a detached session expires after Config.ResumeTimeout instead
+			if !attached {
+				continue
+			}
+			timeout := s.cfg.idleTimeout()
+			// nothing can arrive until ice has the connection back
+			if pc != nil && pc.recovering.Load() {
+				timeout = max(timeout, s.cfg.ResumeTimeout)
+			}
+			if now.Sub(s.LastSeen()) > timeout {
+				s.close(ErrIdleTimeout)
+				return
+			}
+			s.send(s.keepalive.ping(now))
+		}
+	}
+}
+
+func (k *keepalive) ping(now time.Time) []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := k.nextID
+	k.nextID++
+	k.pings[id%keepaliveWindow] = ping{id: id, sentAt: now}
+	return binary.BigEndian.AppendUint32([]byte{kindPing}, id)
+}
+
+// answer a ping right away
+func (s *session) receivePing(packet []byte) {
+	if len(packet) < 5 {
+		return
+	}
+	pong := append([]byte{kindPong}, packet[1:5]...)
+	s.send(pong)
+}
+
+func (s *session) receivePong(packet []byte) {
+	if len(packet) < 5 {
+		return
+	}
+	s.keepalive.pong(binary.BigEndian.Uint32(packet[1:]), time.Now())
+}
+
+func (k *keepalive) pong(id uint32, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	p := &k.pings[id%keepaliveWindow]
+	if p.id != id || p.answered || p.sentAt.IsZero() || now.Sub(p.sentAt) > pongTimeout {
+		return // too late, or answered twice over multiple paths
+	}
+	p.answered = true
+	rtt := now.Sub(p.sentAt)
+	if k.rtt == 0 {
+		k.rtt, k.jitter = rtt, rtt/2
+		return
+	}
+	// rfc 6298
+	k.jitter = (3*k.jitter + (k.rtt - rtt).Abs()) / 4
+	k.rtt = (7*k.rtt + rtt) / 8
+}
+
+// fraction of the recent pings that weren't answered in time
+func (k *keepalive) lossRate(now time.Time) float64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var sent, lost int
+	for _, p := range k.pings {
+		// too early to tell
+		if p.sentAt.IsZero() || (!p.answered && now.Sub(p.sentAt) < pongTimeout) {
+			continue
+		}
+		sent++
+		if !p.answered {
+			lost++
+		}
+	}
+	if sent == 0 {
+		return 0
+	}
+	return float64(lost) / float64(sent)
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	var k keepalive
+	now := time.Now()
+	for i := range 10 {
+		sent := now.Add(time.Duration(i) * time.Second)
+		id := binary.BigEndian.Uint32(k.ping(sent)[1:])
+		// every other ping takes twice as long, every fifth is lost
+		rtt := 40 * time.Millisecond
+		if i%2 == 1 {
+			rtt *= 2
+		}
+		if i%5 != 4 {
+			k.pong(id, sent.Add(rtt))
+			// copies over other paths are ignored
+			k.pong(id, sent.Add(rtt))
+		}
+	}
+	if k.rtt < 40*time.Millisecond || k.rtt > 80*time.Millisecond {
+		t.Errorf("rtt is %v", k.rtt)
+	}
+	if k.jitter < 10*time.Millisecond || k.jitter > 40*time.Millisecond {
+		t.Errorf("jitter is %v", k.jitter)
+	}
+	if loss := k.lossRate(now.Add(20 * time.Second)); loss != 0.2 {
+		t.Errorf("loss rate is %v", loss)
+	}
+
+	// a pong that took too long counts as lost
+	id := binary.BigEndian.Uint32(k.ping(now)[1:])
+	rtt := k.rtt
+	k.pong(id, now.Add(pongTimeout+time.Millisecond))
+	if k.rtt != rtt {
+		t.Error("late pong was measured")
+	}
+	// pings still waiting for their pong are not lost yet
+	k.ping(now.Add(30 * time.Second))
+	if loss := k.lossRate(now.Add(30 * time.Second)); loss != 3.0/11 {
+		t.Errorf("loss rate is %v", loss)
+	}
+}
+
+func TestIdleWhileRecovering(t *testing.T) {
+	cfg := DefaultConfig("", "")
+	cfg.KeepaliveInterval = 20 * time.Millisecond
+	cfg.IdleTimeout = 200 * time.Millisecond
+	cfg.ResumeTimeout = time.Second
+	a, _, _, ba := sessionPair(t, cfg)
+	// ice lost the connection and is getting it back
+	pc := &peerConnection{}
+	pc.recovering.Store(true)
+	a.mu.Lock()
+	a.pc = pc
+	a.mu.Unlock()
+	ba.set(func(e *datagramEnd) { e.down = true })
+
+	select {
+	case <-a.closed:
+		t.Fatalf("closed with %v while ice was recovering", a.err)
+	case <-time.After(3 * cfg.IdleTimeout):
+	}
+	// but not for longer than a detached session waits
+	select {
+	case <-a.closed:
+		if a.err != ErrIdleTimeout {
+			t.Errorf("closed with %v, want ErrIdleTimeout", a.err)
+		}
+	case <-time.After(2 * cfg.ResumeTimeout):
+		t.Error("still open after the resume timeout")
+	}
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// one end of an in memory datagram link between two sessions
+type datagramEnd struct {
+	in     chan []byte
+	remote *datagramEnd
+	closed chan struct{}
+	once   sync.Once
+
+	mu sync.Mutex
+	// every n-th packet written is dropped, 0 drops nothing
+	dropEvery int
+	// drop every packet written, like a dead path
+	down bool
+	// swap every other packet with the one after it
+	reorder bool
+	held    []byte
+	written int
+}
+
+func datagramPipe() (*datagramEnd, *datagramEnd) {
+	a := &datagramEnd{in: make(chan []byte, 4096), closed: make(chan struct{})}
+	b := &datagramEnd{in: make(chan []byte, 4096), closed: make(chan struct{})}
+	a.remote, b.remote = b, a
+	return a, b
+}
+
+func (e *datagramEnd) Read(p []byte) (int, error) {
+	select {
+	case <-e.closed:
+		return 0, net.ErrClosed
+	case packet := <-e.in:
+		return copy(p, packet), nil
+	}
+}
+
+func (e *datagramEnd) Write(p []byte) (int, error) {
+	select {
+	case <-e.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	packet := bytes.Clone(p)
+	e.mu.Lock()
+	e.written++
+	if e.down || (e.dropEvery > 0 && e.written%e.dropEvery == 0) {
+		e.mu.Unlock()
+		return len(p), nil
+	}
+	var out [][]byte
+	switch {
+	case !e.reorder:
+		out = [][]byte{packet}
+	case e.held == nil:
+		e.held = packet
+	default:
+		out = [][]byte{packet, e.held}
+		e.held = nil
+	}
+	e.mu.Unlock()
+	for _, packet := range out {
+		select {
+		case e.remote.in <- packet:
+		default: // queue full, lost like on a real link
+		}
+	}
+	return len(p), nil
+}
+
+func (e *datagramEnd) set(f func(e *datagramEnd)) {
+	e.mu.Lock()
+	f(e)
+	e.mu.Unlock()
+}
+
+func (e *datagramEnd) Close() error {
+	e.once.Do(func() { close(e.closed) })
+	return nil
+}
+func (e *datagramEnd) LocalAddr() net.Addr                { return nil }
+func (e *datagramEnd) RemoteAddr() net.Addr               { return nil }
+func (e *datagramEnd) SetDeadline(t time.Time) error      { return nil }
+func (e *datagramEnd) SetReadDeadline(t time.Time) error  { return nil }
+func (e *datagramEnd) SetWriteDeadline(t time.Time) error { return nil }
+
+// two sessions talking over a datagram pipe, closed when the test ends
+func sessionPair(t *testing.T, cfg Config) (a, b *session, ab, ba *datagramEnd) {
+	ab, ba = datagramPipe()
+	id := uuid.New()
+	a = newSession(id, uuid.UUID{}, nil, ab, cfg, nil)
+	b = newSession(id, uuid.UUID{}, nil, ba, cfg, nil)
+	t.Cleanup(func() {
+		a.close(net.ErrClosed)
+		b.close(net.ErrClosed)
+	})
+	return
+}
+
+// read n messages, failing the test if they don't arrive in time
+func readMessages(t *testing.T, ch *Channel, n int) [][]byte {
+	t.Helper()
+	ch.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer ch.SetReadDeadline(time.Time{})
+	msgs := make([][]byte, 0, n)
+	for range n {
+		msg, err := ch.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %d of %d messages: %v", len(msgs), n, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// nothing else arrives on ch for a while
+func expectNoMessage(t *testing.T, ch *Channel) {
+	t.Helper()
+	ch.SetReadDeadline(time.Now().Add(3 * retransmitTimeout))
+	defer ch.SetReadDeadline(time.Time{})
+	if msg, err := ch.ReadMessage(); err == nil {
+		t.Errorf("unexpected message %v", msg)
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/ice/v4"
+)
+
+// redundant multipath. every data packet is sent over the selected
+// candidate pair and duplicated over the next best pairs that passed their
+// connectivity checks, so a loss spike on one path is masked by the others.
+// the receiver drops the copies by their transport sequence number.
+
+// packets behind the newest one that are still checked for duplicates
+const dedupWindow = 1024
+
+// remembers which transport sequence numbers arrived
+type seqWindow struct {
+	started bool
+	highest uint32
+	seen    [dedupWindow / 64]uint64
+}
+
+// duplicate reports if seq arrived before, and remembers that it did.
+// packets too old to tell are reported as duplicates.
+func (w *seqWindow) duplicate(seq uint32) bool {
+	if !w.started {
+		w.started, w.highest = true, seq
+		w.set(seq)
+		return false
+	}
+	if seqLess(w.highest, seq) {
+		if seq-w.highest >= dedupWindow {
+			w.seen = [dedupWindow / 64]uint64{}
+		} else {
+			for s := w.highest + 1; s != seq; s++ {
+				w.clear(s)
+			}
+		}
+		w.highest = seq
+		w.set(seq)
+		return false
+	}
+	if w.highest-seq >= dedupWindow {
+		return true
+	}
+	i := seq % dedupWindow
+	if w.seen[i/64]&(1<<(i%64)) != 0 {
+		return true
+	}
+	w.set(seq)
+	return false
+}
+
+func (w *seqWindow) set(seq uint32) {
+	i := seq % dedupWindow
+	w.seen[i/64] |= 1 << (i % 64)
+}
+
+func (w *seqWindow) clear(seq uint32) {
+	i := seq % dedupWindow
+	w.seen[i/64] &^= 1 << (i % 64)
+}
+
+// ValidPairs returns up to n candidate pairs other than the selected one
+// that passed their connectivity checks, fastest first.
+func (pc *peerConnection) ValidPairs(n int) []*ice.CandidatePair {
+	locals, err := pc.agent.GetLocalCandidates()
+	if err != nil {
+		return nil
+	}
+	remotes, err := pc.agent.GetRemoteCandidates()
+	if err != nil {
+		return nil
+	}
+	candidates := map[string]ice.Candidate{}
+	for _, c := range append(locals, remotes...) {
+		candidates[c.ID()] = c
+	}
+	selected, _ := pc.agent.GetSelectedCandidatePair()
+
+	stats := pc.agent.GetCandidatePairsStats()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].CurrentRoundTripTime < stats[j].CurrentRoundTripTime
+	})
+	var pairs []*ice.CandidatePair
+	for _, stat := range stats {
+		if len(pairs) >= n {
+			break
+		}
+		local, remote := candidates[stat.LocalCandidateID], candidates[stat.RemoteCandidateID]
+		if stat.State != ice.CandidatePairStateSucceeded || local == nil || remote == nil {
+			continue
+		}
+		if selected != nil && local.String() == selected.Local.String() && remote.String() == selected.Remote.String() {
+			continue
+		}
+		pairs = append(pairs, &ice.CandidatePair{Local: local, Remote: remote})
+	}
+	return pairs
+}
+
+// keep the extra paths up to date with what the agent found
+func (s *session) multipathLoop() {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			pc := s.pc
+			s.mu.Unlock()
+			var paths []*ice.CandidatePair
+			if pc != nil {
+				paths = pc.ValidPairs(s.cfg.Multipath - 1)
+			}
+			s.mu.Lock()
+			if s.pc == pc {
+				s.paths = paths
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// send copies of a data packet over the extra paths
+func (s *session) sendRedundant(packet []byte) {
+	s.mu.Lock()
+	paths := s.paths
+	s.mu.Unlock()
+	for _, path := range paths {
+		if n, err := path.Write(packet); err == nil {
+			s.packetsSent.Add(1)
+			s.bytesSent.Add(uint64(n))
+		}
+	}
+}
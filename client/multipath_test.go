@@ -0,0 +1,30 @@
+package client
+
+import "testing"
+
+func TestSeqWindow(t *testing.T) {
+	var w seqWindow
+	for _, seq := range []uint32{5, 7, 6, 100} {
+		if w.duplicate(seq) {
+			t.Errorf("%d reported as duplicate", seq)
+		}
+	}
+	for _, seq := range []uint32{5, 6, 7, 100} {
+		if !w.duplicate(seq) {
+			t.Errorf("copy of %d not detected", seq)
+		}
+	}
+	if w.duplicate(99) {
+		t.Error("99 reported as duplicate")
+	}
+	// far ahead, and wrapping around
+	w.duplicate(100 + 5*dedupWindow)
+	if !w.duplicate(100) {
+		t.Error("packet older than the window accepted")
+	}
+	w = seqWindow{}
+	w.duplicate(^uint32(0))
+	if w.duplicate(0) || !w.duplicate(^uint32(0)) {
+		t.Error("wraparound")
+	}
+}
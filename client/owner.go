@@ -2,8 +2,10 @@ package client
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"log/slog"
+	"net"
 	"sync"
 	"time"
 
@@ -11,19 +13,34 @@ import (
 
 	"github.com/coder/websocket"
 	"github.com/google/uuid"
+	"github.com/pion/ice/v4"
 )
 
 type Owner struct {
 	connections map[uuid.UUID]*peerConnection
-	connMu      sync.Mutex
-	RoomID      string
-	cfg         Config
-	onConnect   func(conn Conn)
+	// sessions by session id, they outlive the connections of reconnecting guests
+	sessions  map[uuid.UUID]*session
+	connMu    sync.Mutex
+	RoomID    string
+	cfg       Config
+	onConnect func(conn Conn)
+	// guarded by connMu
+	onDisconnect func(conn Conn, reason DisconnectReason)
+	// nil without compression
+	compressor *compressor
 
 	ws ws
+	// stops the event handler, done is closed once it returned
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 func NewOwner(ctx context.Context, onConnect func(conn Conn), cfg Config) (owner *Owner, err error) {
+	compressor, err := newCompressor(cfg)
+	if err != nil {
+		return
+	}
 	conn, _, err := websocket.Dial(ctx, cfg.SignalingServer.String(), nil)
 	if err != nil {
 		return
@@ -35,8 +52,11 @@ func NewOwner(ctx context.Context, onConnect func(conn Conn), cfg Config) (owner
 		ws:          ws{conn},
 		cfg:         cfg,
 		connections: map[uuid.UUID]*peerConnection{},
+		sessions:    map[uuid.UUID]*session{},
 		onConnect:   onConnect,
+		compressor:  compressor,
 		connMu:      sync.Mutex{},
+		done:        make(chan struct{}),
 	}
 	err = owner.ws.WriteMsg(ctx, message.CreateRoomMsg())
 	if err != nil {
@@ -52,29 +72,31 @@ func NewOwner(ctx context.Context, onConnect func(conn Conn), cfg Config) (owner
 		return nil, fmt.Errorf("invalid response type from server")
 	}
 	owner.RoomID = msg.RoomID
+	ctx, owner.cancel = context.WithCancel(ctx)
 	go owner.eventHandler(ctx)
 	return
 }
 func (owner *Owner) eventHandler(ctx context.Context) {
+	defer close(owner.done)
+	// losing the signaling server doesn't end the sessions,
+	// they carry on over their ice connections. only Close ends them
 	for {
-		select {
-		case <-ctx.Done():
+		msg, err := owner.ws.ReadMsg(ctx)
+		if ctx.Err() != nil {
 			owner.ws.Close(websocket.StatusGoingAway, "room shut down")
-			owner.disconnectAll()
 			return
-		default:
-			msg, err := owner.ws.ReadMsg(ctx)
-			if err != nil {
-				slog.Error("failed to read message", "error", err)
-				owner.disconnectAll()
-				return
-			}
-			err = owner.handleMsg(ctx, msg)
-			if err != nil {
-				slog.Error("failed to handle message", "type", msg.Type, "error", err)
-				owner.disconnectAll()
-				return
-			}
+		}
+		if err != nil {
+			slog.Error("failed to read message", "error", err)
+			owner.cfg.emit(Event{Type: EventSignalingLost, Err: err})
+			return
+		}
+		err = owner.handleMsg(ctx, msg)
+		if err != nil {
+			slog.Error("failed to handle message", "type", msg.Type, "error", err)
+			owner.ws.Close(websocket.StatusInternalError, "failed to handle message")
+			owner.cfg.emit(Event{Type: EventSignalingLost, Err: err})
+			return
 		}
 	}
 }
@@ -83,13 +105,20 @@ func (owner *Owner) eventHandler(ctx context.Context) {
 func (owner *Owner) handleMsg(ctx context.Context, msg message.Msg) error {
 	switch msg.Type {
 	case message.IceAuthInitiate:
+		// the session expired, the guest can't pick up where it left off
+		if msg.Resume && owner.getSession(msg.Session) == nil {
+			owner.rejectResume(ctx, msg)
+			return nil
+		}
 		remoteUfrag, remotePwd := msg.Ufrag, msg.Pwd
 		pc, ufrag, pwd, err := newPeerConnection(owner.cfg.AgentCfg)
 		if err != nil {
 			return err
 		}
 		owner.addConnection(msg.From, pc)
-		err = owner.ws.WriteMsg(ctx, message.IceAuthResponseMsg(ufrag, pwd, msg.From))
+		owner.cfg.emit(Event{Type: EventJoinRequest, Peer: msg.Session})
+		compression := owner.compressor.negotiate(msg.Compression)
+		err = owner.ws.WriteMsg(ctx, message.IceAuthResponseMsg(ufrag, pwd, msg.From, compression.offer()))
 		if err != nil {
 			owner.deleteConnection(msg.From)
 			slog.Debug("failed to write to guest connection", "error", err)
@@ -100,20 +129,86 @@ func (owner *Owner) handleMsg(ctx context.Context, msg message.Msg) error {
 			conn, err := pc.Dial(ctx, remoteUfrag, remotePwd)
 			if err != nil {
 				slog.Error("failed to dial", "error", err)
+				if owner.getConnection(msg.From) == pc {
+					owner.deleteConnection(msg.From)
+				}
+				pc.Close()
+				return
+			}
+			s := owner.getSession(msg.Session)
+			// expired while connecting
+			if msg.Resume && s == nil {
+				owner.deleteConnection(msg.From)
+				pc.Close()
+				owner.rejectResume(ctx, msg)
+				return
+			}
+			// guest reconnected, carry on with the session it had
+			if s != nil {
+				if s.secret == "" || subtle.ConstantTimeCompare([]byte(s.secret), []byte(msg.Secret)) != 1 {
+					slog.Warn("refused to resume a session with the wrong secret", "session", msg.Session)
+					owner.deleteConnection(msg.From)
+					pc.Close()
+					return
+				}
+				if old := owner.getConnection(s.peerID()); old != nil && old != pc {
+					owner.deleteConnection(s.peerID())
+					old.Close()
+				}
+				s.rebind(msg.From, pc, conn)
 				return
 			}
-			owner.onConnect(newPacketConn(msg.From, conn))
+			s = newSession(msg.Session, msg.From, pc, conn, owner.cfg, compression)
+			s.metadata = msg.Metadata
+			s.secret = msg.Secret
+			owner.addSession(s)
+			go owner.serveTopics(s)
+			owner.onConnect(Conn{s})
 		}()
 		// forward locally gathered ice candidates
+		// this keeps running because an ice restart gathers again
 		go func() {
-			for c := range pc.localCandidates {
-				err = owner.ws.WriteMsg(ctx, message.IceCandidateForGuestMsg(c, msg.From))
-				if err != nil {
-					slog.Debug("error sending ice candidate", "error", err)
+			for {
+				select {
+				case <-ctx.Done():
 					return
+				case <-pc.done:
+					return
+				case c := <-pc.localCandidates:
+					err := owner.ws.WriteMsg(ctx, message.IceCandidateForGuestMsg(c, msg.From))
+					if err != nil {
+						slog.Debug("error sending ice candidate", "error", err)
+						return
+					}
 				}
 			}
 		}()
+		go owner.watchConnectionState(ctx, msg.Session, pc)
+	// guest lost the connection and restarted ice
+	case message.IceRestart:
+		pc := owner.getConnection(msg.From)
+		if pc == nil {
+			slog.Debug("ice restart for a connection not in map", "id", msg.From)
+			owner.rejectRestart(ctx, msg.From, "not connected")
+			return nil
+		}
+		ufrag, pwd, err := pc.Restart()
+		if err != nil {
+			slog.Debug("failed to restart ice", "id", msg.From, "error", err)
+			owner.rejectRestart(ctx, msg.From, err.Error())
+			return nil
+		}
+		err = pc.Renegotiate(msg.Ufrag, msg.Pwd)
+		if err != nil {
+			slog.Debug("failed to renegotiate ice", "id", msg.From, "error", err)
+			owner.rejectRestart(ctx, msg.From, err.Error())
+			return nil
+		}
+		err = owner.ws.WriteMsg(ctx, message.IceRestartResponseMsg(ufrag, pwd, msg.From))
+		if err != nil {
+			slog.Debug("failed to write to guest connection", "error", err)
+			return nil
+		}
 		// receive remote candidates
 	case message.IceCandidateForOwner:
 		pc := owner.getConnection(msg.From)
@@ -131,8 +226,24 @@ func (owner *Owner) handleMsg(ctx context.Context, msg message.Msg) error {
 			slog.Debug("ask to disconnect a non-connected peer")
 			return nil
 		}
-		pc.agent.Close()
+		owner.deleteConnection(msg.From)
+		pc.Close()
+		// give the guest some time to come back
+		if s := owner.sessionOf(msg.From); s != nil {
+			s.setReason(DisconnectSignalingLost)
+			s.detach(owner.cfg.ResumeTimeout)
+		}
 
+	// the guest left the room, its close notice may have been lost
+	case message.Leave:
+		if pc := owner.getConnection(msg.From); pc != nil {
+			owner.deleteConnection(msg.From)
+			pc.Close()
+		}
+		if s := owner.sessionOf(msg.From); s != nil {
+			s.setReason(DisconnectRemoteClosed)
+			s.close(ErrRemoteClosed)
+		}
 	case message.Ping:
 		return nil
 	default:
@@ -140,16 +251,66 @@ func (owner *Owner) handleMsg(ctx context.Context, msg message.Msg) error {
 	}
 	return nil
 }
+
+// drain the connection state of a guest and report it.
+// the guest is the one restarting ice when the connection is lost.
+func (owner *Owner) watchConnectionState(ctx context.Context, session uuid.UUID, pc *peerConnection) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pc.done:
+			return
+		case change := <-pc.pairChanges:
+			owner.cfg.emit(Event{Type: EventPairChanged, Peer: session, Local: change.local, Remote: change.remote})
+		case cs := <-pc.connectionState:
+			slog.Debug("guest connection state changed", "session", session, "state", cs.String())
+			if typ, ok := stateEvent(cs); ok {
+				owner.cfg.emit(Event{Type: typ, Peer: session})
+			}
+			if s := owner.getSession(session); s != nil {
+				switch cs {
+				case ice.ConnectionStateFailed:
+					s.setReason(DisconnectICEFailed)
+				case ice.ConnectionStateConnected:
+					s.clearReason(DisconnectICEFailed)
+				}
+			}
+			if cs == ice.ConnectionStateClosed {
+				return
+			}
+		}
+	}
+}
 func (owner *Owner) addConnection(id uuid.UUID, pc *peerConnection) {
 	owner.connMu.Lock()
 	owner.connections[id] = pc
 	owner.connMu.Unlock()
 }
+
+// Kick the guest out of the room, see [Owner.KickWithReason]
 func (owner *Owner) Kick(conn Conn) {
+	owner.KickWithReason(conn, "")
+}
+
+// KickWithReason kicks the guest out of the room and tells it why.
+// the guest's Conn returns an error wrapping ErrKicked, with the reason.
+// reasons longer than 255 bytes are cut short, on a character boundary
+func (owner *Owner) KickWithReason(conn Conn, reason string) {
+	reason = truncateReason(reason)
+	peer := conn.peerID()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	owner.ws.WriteMsg(ctx, message.KickMsg(conn.iD))
+	owner.ws.WriteMsg(ctx, message.KickMsg(peer, reason))
 	cancel()
-	owner.deleteConnection(conn.iD)
+	owner.cfg.emit(Event{Type: EventKicked, Peer: conn.ID(), Cause: reason})
+	conn.setReason(DisconnectKicked)
+	pc := owner.getConnection(peer)
+	owner.deleteConnection(peer)
+	owner.deleteSession(conn.ID())
+	conn.shutdown(net.ErrClosed, append([]byte{closeKick}, reason...)...)
+	if pc != nil {
+		pc.Close()
+	}
 }
 
 // does not disconnect, only deletes from map
@@ -166,10 +327,82 @@ func (owner *Owner) getConnection(id uuid.UUID) *peerConnection {
 
 	return owner.connections[id]
 }
+
+// tell the guest its session is gone, so it doesn't wait for it to come back
+func (owner *Owner) rejectResume(ctx context.Context, msg message.Msg) {
+	slog.Debug("refused to resume an unknown session", "session", msg.Session)
+	err := owner.ws.WriteMsg(ctx, message.ResumeRejectedMsg(msg.From))
+	if err != nil {
+		slog.Debug("failed to write to guest connection", "error", err)
+	}
+}
+
+// tell the guest its ice restart failed, so it can reconnect instead
+func (owner *Owner) rejectRestart(ctx context.Context, guest uuid.UUID, cause string) {
+	err := owner.ws.WriteMsg(ctx, message.IceRestartFailedMsg(guest, cause))
+	if err != nil {
+		slog.Debug("failed to write to guest connection", "error", err)
+	}
+}
+
+// tell every guest the room is closed, and close their connections. only for Close
 func (owner *Owner) disconnectAll() {
 	owner.connMu.Lock()
 	defer owner.connMu.Unlock()
+	// the close notices go out before the agents are closed
+	for _, s := range owner.sessions {
+		s.shutdown(net.ErrClosed, closeRoom)
+	}
 	for _, conn := range owner.connections {
-		conn.agent.Close()
+		conn.Close()
+	}
+}
+
+func (owner *Owner) addSession(s *session) {
+	owner.connMu.Lock()
+	owner.sessions[s.id] = s
+	owner.connMu.Unlock()
+	// forget the session once it is closed or expired
+	go func() {
+		<-s.Done()
+		owner.deleteSession(s.id)
+		// the agent of the guest's last connection
+		if pc := owner.getConnection(s.peerID()); pc != nil {
+			owner.deleteConnection(s.peerID())
+			pc.Close()
+		}
+		reason := s.disconnectReason()
+		owner.cfg.emit(Event{Type: EventClosed, Peer: s.id, Err: s.err, Reason: reason})
+		owner.connMu.Lock()
+		onDisconnect := owner.onDisconnect
+		owner.connMu.Unlock()
+		if onDisconnect != nil {
+			onDisconnect(Conn{s}, reason)
+		}
+	}()
+}
+func (owner *Owner) deleteSession(id uuid.UUID) {
+	owner.connMu.Lock()
+	delete(owner.sessions, id)
+	owner.connMu.Unlock()
+}
+
+// Could be nil
+func (owner *Owner) getSession(id uuid.UUID) *session {
+	owner.connMu.Lock()
+	defer owner.connMu.Unlock()
+
+	return owner.sessions[id]
+}
+
+// session currently bound to the peer with this signaling id. Could be nil
+func (owner *Owner) sessionOf(peer uuid.UUID) *session {
+	owner.connMu.Lock()
+	defer owner.connMu.Unlock()
+	for _, s := range owner.sessions {
+		if s.peerID() == peer {
+			return s
+		}
 	}
+	return nil
 }
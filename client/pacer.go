@@ -0,0 +1,132 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// bytes that may wait in the pacer before new packets are dropped
+	maxPacedBytes = 512 << 10
+	// how far ahead of the rate the pacer may burst
+	pacerBurst = 20 * time.Millisecond
+)
+
+// Priority decides which of the messages waiting to be sent goes first.
+// Messages only wait while writes are paced, see [Config].CongestionControl.
+type Priority int8
+
+const (
+	// bulk transfers, sent when nothing else is waiting
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	// input and control messages, sent ahead of everything else
+	PriorityHigh
+)
+
+// the pacer spreads data packets out to the rate of the congestion controller,
+// so bursts of snapshots don't flood weak uplinks.
+// higher priority packets are sent first.
+type pacer struct {
+	s *session
+
+	mu sync.Mutex
+	// a queue per priority, lowest first
+	queues [PriorityHigh - PriorityLow + 1][]pacedPacket
+	queued int
+	wake   chan struct{}
+}
+
+type pacedPacket struct {
+	packet []byte
+	ch     *Channel
+	// dropped if it can't be sent before, zero for never
+	deadline time.Time
+}
+
+func newPacer(s *session) *pacer {
+	return &pacer{s: s, wake: make(chan struct{}, 1)}
+}
+
+// queue a packet to be sent. if the queue is full, lower priority packets
+// make room for it or it is dropped, like with a full socket buffer.
+func (p *pacer) enqueue(packet pacedPacket) {
+	i := packet.ch.Priority() - PriorityLow
+	p.mu.Lock()
+	for lower := range i {
+		for p.queued+len(packet.packet) > maxPacedBytes && len(p.queues[lower]) > 0 {
+			p.pop(lower)
+		}
+	}
+	if p.queued+len(packet.packet) > maxPacedBytes {
+		p.mu.Unlock()
+		return
+	}
+	p.queues[i] = append(p.queues[i], packet)
+	p.queued += len(packet.packet)
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// must be called with p.mu held
+func (p *pacer) pop(i Priority) pacedPacket {
+	packet := p.queues[i][0]
+	p.queues[i][0] = pacedPacket{}
+	p.queues[i] = p.queues[i][1:]
+	p.queued -= len(packet.packet)
+	return packet
+}
+
+// the next packet to send, from the highest priority queue
+func (p *pacer) dequeue() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for i := len(p.queues) - 1; i >= 0; i-- {
+		for len(p.queues[i]) > 0 {
+			packet := p.pop(Priority(i))
+			if packet.ch.late(packet.deadline, now) {
+				continue
+			}
+			return packet.packet
+		}
+	}
+	return nil
+}
+
+func (p *pacer) loop() {
+	var budget float64 // bytes that can be sent right now
+	last := time.Now()
+	for {
+		packet := p.dequeue()
+		for packet == nil {
+			select {
+			case <-p.s.closed:
+				return
+			case <-p.wake:
+			}
+			packet = p.dequeue()
+		}
+		for {
+			rate := float64(p.s.cc.controller.SendRate())
+			now := time.Now()
+			burst := max(rate*pacerBurst.Seconds(), float64(len(packet)))
+			budget = min(budget+rate*now.Sub(last).Seconds(), burst)
+			last = now
+			if budget >= float64(len(packet)) || rate <= 0 {
+				break
+			}
+			wait := time.Duration((float64(len(packet)) - budget) / rate * float64(time.Second))
+			select {
+			case <-p.s.closed:
+				return
+			case <-time.After(max(wait, time.Millisecond)):
+			}
+		}
+		budget -= float64(len(packet))
+		p.s.sendTransport(packet)
+	}
+}
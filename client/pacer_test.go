@@ -0,0 +1,31 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacerPriority(t *testing.T) {
+	s := &session{}
+	p := newPacer(s)
+	bulk, normal, input := newChannel(s, 1, Reliable), newChannel(s, 2, Unreliable), newChannel(s, 3, Unreliable)
+	bulk.SetPriority(PriorityLow)
+	input.SetPriority(PriorityHigh)
+
+	p.enqueue(pacedPacket{packet: []byte("bulk"), ch: bulk})
+	p.enqueue(pacedPacket{packet: []byte("stale"), ch: input, deadline: time.Now().Add(-time.Second)})
+	p.enqueue(pacedPacket{packet: []byte("normal"), ch: normal})
+	p.enqueue(pacedPacket{packet: []byte("input"), ch: input, deadline: time.Now().Add(time.Second)})
+
+	for _, want := range []string{"input", "normal", "bulk"} {
+		if got := string(p.dequeue()); got != want {
+			t.Errorf("sent %q, expected %q", got, want)
+		}
+	}
+	if p.dequeue() != nil {
+		t.Error("queue not empty")
+	}
+	if n := input.Stats().DroppedDeadline; n != 1 {
+		t.Errorf("%d messages dropped for their deadline, expected 1", n)
+	}
+}
@@ -2,6 +2,10 @@ package client
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/pion/ice/v4"
 )
 
@@ -10,6 +14,13 @@ import (
 type peerConnection struct {
 	localCandidates chan string // candidates gathered
 	connectionState chan ice.ConnectionState
+	pairChanges     chan pairChange
+	// closed with the peer connection
+	done      chan struct{}
+	closeOnce sync.Once
+	// set while ice is trying to get a lost connection back,
+	// by itself or after a restart
+	recovering atomic.Bool
 
 	agent *ice.Agent
 }
@@ -27,17 +38,39 @@ func newPeerConnection(cfg ice.AgentConfig) (pc *peerConnection, ufrag, pwd stri
 		agent:           agent,
 		localCandidates: make(chan string, 50),
 		connectionState: make(chan ice.ConnectionState, 10),
+		pairChanges:     make(chan pairChange, 10),
+		done:            make(chan struct{}),
 	}
 
 	agent.OnCandidate(func(c ice.Candidate) {
+		// gathering finished. the channel stays open
+		// because an ice restart gathers again
 		if c == nil {
-			close(pc.localCandidates)
 			return
 		}
-		pc.localCandidates <- c.Marshal()
+		select {
+		case pc.localCandidates <- c.Marshal():
+		case <-pc.done: // nobody forwards them anymore
+		}
 	})
 	agent.OnConnectionStateChange(func(cs ice.ConnectionState) {
-		pc.connectionState <- cs
+		switch cs {
+		// a restart goes back to checking
+		case ice.ConnectionStateDisconnected, ice.ConnectionStateFailed, ice.ConnectionStateChecking:
+			pc.recovering.Store(true)
+		case ice.ConnectionStateConnected, ice.ConnectionStateCompleted:
+			pc.recovering.Store(false)
+		}
+		select {
+		case pc.connectionState <- cs:
+		default: // nobody is listening, don't hold up the agent
+		}
+	})
+	agent.OnSelectedCandidatePairChange(func(local, remote ice.Candidate) {
+		select {
+		case pc.pairChanges <- pairChange{candidateStats(local), candidateStats(remote)}:
+		default: // nobody is listening, only the latest one matters anyway
+		}
 	})
 
 	// start gathering candidates to channel
@@ -67,6 +100,24 @@ func (pc *peerConnection) ConnectionState() <-chan ice.ConnectionState {
 	return pc.connectionState
 }
 
+// SelectedPair returns both ends of the candidate pair the agent sends over,
+// and the round trip time of its latest connectivity check.
+// ok is false while no pair is selected.
+func (pc *peerConnection) SelectedPair() (local, remote CandidateStats, rtt time.Duration, ok bool) {
+	pair, err := pc.agent.GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		return
+	}
+	if stats, found := pc.agent.GetSelectedCandidatePairStats(); found {
+		rtt = time.Duration(stats.CurrentRoundTripTime * float64(time.Second))
+	}
+	return candidateStats(pair.Local), candidateStats(pair.Remote), rtt, true
+}
+
+func candidateStats(c ice.Candidate) CandidateStats {
+	return CandidateStats{Type: c.Type(), Address: c.Address(), Port: c.Port()}
+}
+
 // Dial connects to the remote agent, acting as the controlling ice agent.
 // Dial blocks until at least one ice candidate pair has successfully connected.
 func (pc *peerConnection) Dial(ctx context.Context, remoteUfrag, remotePwd string) (*ice.Conn, error) {
@@ -78,3 +129,24 @@ func (pc *peerConnection) Dial(ctx context.Context, remoteUfrag, remotePwd strin
 func (pc *peerConnection) Accept(ctx context.Context, remoteUfrag, remotePwd string) (*ice.Conn, error) {
 	return pc.agent.Accept(ctx, remoteUfrag, remotePwd)
 }
+
+// Restart throws away all candidates and generates fresh local credentials.
+// The *ice.Conn returned by Dial or Accept stays usable,
+// it carries traffic again once the restart is negotiated with [peerConnection.Renegotiate].
+func (pc *peerConnection) Restart() (ufrag, pwd string, err error) {
+	err = pc.agent.Restart("", "")
+	if err != nil {
+		return
+	}
+	return pc.agent.GetLocalUserCredentials()
+}
+
+// Renegotiate sets the credentials the remote agent got after restarting,
+// and gathers a new set of local candidates.
+func (pc *peerConnection) Renegotiate(remoteUfrag, remotePwd string) error {
+	err := pc.agent.SetRemoteCredentials(remoteUfrag, remotePwd)
+	if err != nil {
+		return err
+	}
+	return pc.agent.GatherCandidates()
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// path mtu discovery, loosely following DPLPMTUD (RFC 8899).
+// padded probe packets are sent over the selected candidate pair,
+// the largest one the remote acknowledges is the size datagrams can have
+// without being dropped or fragmented by ip.
+const (
+	// largest udp payload in an ethernet frame
+	maxDatagramSizeIPv4 = 1500 - 20 - 8
+	maxDatagramSizeIPv6 = 1500 - 40 - 8
+	// a probe size is given up on after this many lost probes
+	maxProbes = 3
+	// wait this long for a probe to be acknowledged
+	probeTimeout = time.Second
+	// stop searching once the window is smaller than this
+	probeGranularity = 16
+	// search again in case the path got larger
+	probeRaiseInterval = 10 * time.Minute
+	// how often the selected candidate pair is checked for changes
+	probeInterval = 250 * time.Millisecond
+)
+
+type pathMTU struct {
+	mu sync.Mutex
+	// largest datagram confirmed to make it through
+	size int
+	// addresses of the candidate pair being probed
+	path string
+	// search window, lo is confirmed and hi is not ruled out yet
+	lo, hi int
+	// largest datagram the address family allows
+	max int
+
+	probeID   uint32
+	probeSize int
+	probing   bool
+	sentAt    time.Time
+	attempts  int
+	searched  time.Time
+}
+
+// MaxPayloadSize is the largest message written with Write or WriteReliable
+// that fits in one datagram on the current path. Larger messages are fragmented.
+// Other channels can have less room, see [Channel.MaxPayloadSize].
+func (s *session) MaxPayloadSize() int {
+	return s.main.MaxPayloadSize()
+}
+
+// MaxPayloadSize is the largest message that fits in one datagram
+// on the current path, with the channel's current headers. Larger messages are fragmented.
+func (ch *Channel) MaxPayloadSize() int {
+	// a message that isn't fragmented only has the flags in front of it
+	return ch.maxBody() - 1
+}
+
+// largest message body that fits in one datagram
+func (ch *Channel) maxBody() int {
+	return ch.s.datagramSize() - ch.headerSize() - ch.s.overhead()
+}
+
+// largest datagram that can be sent on the current path
+func (s *session) datagramSize() int {
+	s.pmtu.mu.Lock()
+	defer s.pmtu.mu.Unlock()
+	if s.pmtu.size == 0 {
+		return defaultDatagramSize
+	}
+	return s.pmtu.size
+}
+
+func (s *session) pmtuLoop() {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			if probe := s.pmtu.next(now, s.LocalAddr(), s.RemoteAddr()); probe != nil {
+				s.send(probe)
+			}
+		}
+	}
+}
+
+// decide on the next probe to send, if any
+func (p *pathMTU) next(now time.Time, local, remote net.Addr) []byte {
+	if local == nil || remote == nil {
+		return nil // waiting to be resumed
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// candidate pair changed, start over from the base size
+	if path := local.String() + "|" + remote.String(); path != p.path {
+		p.path = path
+		p.size = defaultDatagramSize
+		p.max = maxDatagramSizeIPv4
+		if addr, ok := remote.(*net.UDPAddr); ok && addr.IP.To4() == nil {
+			p.max = maxDatagramSizeIPv6
+		}
+		p.lo, p.hi = defaultDatagramSize, p.max
+		p.probing = false
+		p.searched = time.Time{}
+	}
+	if p.probing {
+		if now.Sub(p.sentAt) < probeTimeout {
+			return nil
+		}
+		p.probing = false
+		p.attempts++
+		if p.attempts >= maxProbes {
+			// too large for the path
+			p.hi = p.probeSize - 1
+			p.attempts = 0
+		}
+	}
+	if p.hi-p.lo < probeGranularity {
+		if p.searched.IsZero() {
+			p.searched = now
+		}
+		if now.Sub(p.searched) < probeRaiseInterval {
+			return nil
+		}
+		// the path may allow larger datagrams by now
+		p.searched = time.Time{}
+		p.hi = p.max
+	}
+	if p.attempts == 0 {
+		p.probeSize = (p.lo + p.hi + 1) / 2
+	}
+	p.probeID++
+	p.probing = true
+	p.sentAt = now
+
+	probe := make([]byte, p.probeSize)
+	probe[0] = kindProbe
+	binary.BigEndian.PutUint32(probe[1:], p.probeID)
+	return probe
+}
+
+// the remote received a probe
+func (p *pathMTU) acknowledged(id uint32, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.probing || id != p.probeID || size != p.probeSize {
+		return // late ack of an older probe
+	}
+	p.probing = false
+	p.attempts = 0
+	p.lo = size
+	p.size = size
+}
+
+// answer a probe, telling the remote how large it was
+func (s *session) receiveProbe(probe []byte) {
+	if len(probe) < 5 {
+		return
+	}
+	ack := []byte{kindProbeAck}
+	ack = append(ack, probe[1:5]...)
+	ack = binary.BigEndian.AppendUint16(ack, uint16(len(probe)))
+	s.send(ack)
+}
+
+func (s *session) receiveProbeAck(ack []byte) {
+	if len(ack) < 7 {
+		return
+	}
+	s.pmtu.acknowledged(binary.BigEndian.Uint32(ack[1:]), int(binary.BigEndian.Uint16(ack[5:])))
+}
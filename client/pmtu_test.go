@@ -0,0 +1,133 @@
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	probeLocal  = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	probeRemote = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2000}
+)
+
+// run the search over a path that drops datagrams larger than limit,
+// until it settles. returns the sizes probed, in order
+func discover(p *pathMTU, now time.Time, local, remote net.Addr, limit int) (sizes []int) {
+	for range 100 {
+		probe := p.next(now, local, remote)
+		if probe == nil {
+			return
+		}
+		sizes = append(sizes, len(probe))
+		if len(probe) <= limit {
+			p.acknowledged(binary.BigEndian.Uint32(probe[1:]), len(probe))
+		}
+		now = now.Add(probeTimeout)
+	}
+	return
+}
+
+func TestPathMTUSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+	}{
+		{"nothing larger than the base", defaultDatagramSize},
+		{"tunnel", 1400},
+		{"pppoe", 1492 - 20 - 8},
+		{"ethernet", maxDatagramSizeIPv4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p pathMTU
+			sizes := discover(&p, time.Now(), probeLocal, probeRemote, tt.limit)
+			if p.size > tt.limit || tt.limit-p.size >= probeGranularity {
+				t.Errorf("found %d for a limit of %d", p.size, tt.limit)
+			}
+			// a binary search over the window, retrying lost probes
+			if len(sizes) > 8*maxProbes {
+				t.Errorf("took %d probes: %v", len(sizes), sizes)
+			}
+			for _, size := range sizes {
+				if size <= defaultDatagramSize || size > maxDatagramSizeIPv4 {
+					t.Errorf("probed %d, outside the search window", size)
+				}
+			}
+		})
+	}
+}
+
+func TestPathMTUMaxProbes(t *testing.T) {
+	var p pathMTU
+	now := time.Now()
+	first := len(p.next(now, probeLocal, probeRemote))
+	// every probe is lost
+	for i := 1; i < maxProbes; i++ {
+		now = now.Add(probeTimeout)
+		if size := len(p.next(now, probeLocal, probeRemote)); size != first {
+			t.Fatalf("probe %d is %d bytes, want a retry of %d", i, size, first)
+		}
+	}
+	now = now.Add(probeTimeout)
+	if size := len(p.next(now, probeLocal, probeRemote)); size >= first {
+		t.Errorf("still probing %d after %d lost probes of %d", size, maxProbes, first)
+	}
+	if p.hi != first-1 {
+		t.Errorf("window ends at %d, want %d", p.hi, first-1)
+	}
+}
+
+func TestPathMTUPathChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote net.Addr
+		max    int
+	}{
+		{"ipv4", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 2000}, maxDatagramSizeIPv4},
+		{"ipv6", &net.UDPAddr{IP: net.ParseIP("fd00::3"), Port: 2000}, maxDatagramSizeIPv6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p pathMTU
+			now := time.Now()
+			discover(&p, now, probeLocal, probeRemote, maxDatagramSizeIPv4)
+			if p.next(now.Add(time.Minute), probeLocal, probeRemote) != nil {
+				t.Fatal("probing again before probeRaiseInterval")
+			}
+
+			probe := p.next(now.Add(time.Minute), probeLocal, tt.remote)
+			if p.size != defaultDatagramSize || p.lo != defaultDatagramSize || p.hi != tt.max {
+				t.Errorf("size %d and window [%d, %d] after the path changed", p.size, p.lo, p.hi)
+			}
+			if want := (defaultDatagramSize + tt.max + 1) / 2; len(probe) != want {
+				t.Errorf("first probe is %d bytes, want %d", len(probe), want)
+			}
+			// an ack for a probe sent over the old path is ignored
+			p.acknowledged(p.probeID-1, maxDatagramSizeIPv4)
+			if p.size != defaultDatagramSize {
+				t.Errorf("size %d after a late ack", p.size)
+			}
+		})
+	}
+}
+
+func TestMaxPayloadSize(t *testing.T) {
+	a, _, _, _ := sessionPair(t, DefaultConfig("", ""))
+	fec := a.Channel(1, Unreliable)
+	fec.SetFEC(4)
+	channels := []*Channel{a.main, a.Channel(2, Reliable), fec}
+	if fec.MaxPayloadSize() >= a.MaxPayloadSize() {
+		t.Errorf("fec leaves %d bytes, as much as %d without it", fec.MaxPayloadSize(), a.MaxPayloadSize())
+	}
+	for _, ch := range channels {
+		// the largest message is one datagram, one more byte is fragmented
+		for size, want := range map[int]int{ch.MaxPayloadSize(): 1, ch.MaxPayloadSize() + 1: 2} {
+			bodies, err := ch.bodies(make([]byte, size))
+			if err != nil || len(bodies) != want {
+				t.Errorf("channel %d: %d bytes took %d datagrams, want %d", ch.ID(), size, len(bodies), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// room wide publish/subscribe. guests tell the owner which topics they
+// subscribe to, and the owner sends what it publishes to every guest
+// subscribed to the topic, over channels reserved for it.
+const (
+	// reliable topics, and subscribing to topics
+	channelTopics uint8 = 254
+	// best effort topics
+	channelTopicsUnreliable uint8 = 253
+
+	// queued messages of a subscription
+	subscriptionQueueSize = 64
+)
+
+// topic messages start with one of these, followed by the topic
+const (
+	topicSubscribe byte = iota + 1
+	topicUnsubscribe
+	topicPublish // followed by the payload
+)
+
+func topicMessage(op byte, topic string, payload []byte) []byte {
+	msg := binary.AppendUvarint([]byte{op}, uint64(len(topic)))
+	msg = append(msg, topic...)
+	return append(msg, payload...)
+}
+
+func parseTopicMessage(msg []byte) (op byte, topic string, payload []byte, ok bool) {
+	if len(msg) < 2 {
+		return
+	}
+	size, n := binary.Uvarint(msg[1:])
+	if n <= 0 || uint64(len(msg)-1-n) < size {
+		return
+	}
+	start := 1 + n
+	return msg[0], string(msg[start : start+int(size)]), msg[start+int(size):], true
+}
+
+// Publish sends p to every guest subscribed to topic.
+// It may be lost, like [Conn.Write].
+func (owner *Owner) Publish(topic string, p []byte) error {
+	return owner.publish(topic, p, channelTopicsUnreliable, Unreliable)
+}
+
+// PublishReliable sends p to every guest subscribed to topic,
+// so that it arrives in order with the other reliable messages of the topic.
+// It blocks while a guest has too many messages waiting for acknowledgement.
+func (owner *Owner) PublishReliable(topic string, p []byte) error {
+	return owner.publish(topic, p, channelTopics, Reliable)
+}
+
+func (owner *Owner) publish(topic string, p []byte, channel uint8, mode Mode) error {
+	msg := topicMessage(topicPublish, topic, p)
+	var errs []error
+	for _, s := range owner.subscribers(topic) {
+		_, err := s.Channel(channel, mode).Write(msg)
+		if errors.Is(err, ErrMessageTooLarge) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sessions subscribed to topic
+func (owner *Owner) subscribers(topic string) []*session {
+	owner.connMu.Lock()
+	defer owner.connMu.Unlock()
+	var sessions []*session
+	for _, s := range owner.sessions {
+		s.mu.Lock()
+		if s.topics[topic] {
+			sessions = append(sessions, s)
+		}
+		s.mu.Unlock()
+	}
+	return sessions
+}
+
+// keep track of the topics a guest subscribes to, until the session ends
+func (owner *Owner) serveTopics(s *session) {
+	ch := s.Channel(channelTopics, Reliable)
+	for {
+		msg, err := ch.ReadMessage()
+		if err != nil {
+			return
+		}
+		op, topic, _, ok := parseTopicMessage(msg)
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		switch op {
+		case topicSubscribe:
+			s.topics[topic] = true
+		case topicUnsubscribe:
+			delete(s.topics, topic)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Subscription receives the messages the owner publishes to a topic.
+type Subscription struct {
+	topic    string
+	guest    *Guest
+	messages chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Subscribe to messages the owner publishes to topic.
+// A guest can subscribe to a topic more than once, every subscription gets every message.
+//
+// Reliable messages are delivered to the subscriptions one at a time, in order.
+// A subscription that is not read while its queue is full holds back the reliable
+// messages of every topic the guest subscribed to, until it is read or closed.
+func (guest *Guest) Subscribe(topic string) (*Subscription, error) {
+	sub := &Subscription{
+		topic:    topic,
+		guest:    guest,
+		messages: make(chan []byte, subscriptionQueueSize),
+		closed:   make(chan struct{}),
+	}
+	guest.topicsOnce.Do(func() {
+		go guest.receiveTopics(channelTopics, Reliable)
+		go guest.receiveTopics(channelTopicsUnreliable, Unreliable)
+	})
+	guest.topicsMu.Lock()
+	first := len(guest.topics[topic]) == 0
+	guest.topics[topic] = append(guest.topics[topic], sub)
+	guest.topicsMu.Unlock()
+	if first {
+		_, err := guest.conn.Channel(channelTopics, Reliable).Write(topicMessage(topicSubscribe, topic, nil))
+		if err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// dispatch published messages to subscriptions
+func (guest *Guest) receiveTopics(channel uint8, mode Mode) {
+	ch := guest.conn.Channel(channel, mode)
+	for {
+		msg, err := ch.ReadMessage()
+		if err != nil {
+			return
+		}
+		op, topic, payload, ok := parseTopicMessage(msg)
+		if !ok || op != topicPublish {
+			continue
+		}
+		guest.topicsMu.Lock()
+		subs := append([]*Subscription(nil), guest.topics[topic]...)
+		guest.topicsMu.Unlock()
+		// every subscription gets its own copy,
+		// the last one gets payload once the others were copied
+		for i, sub := range subs {
+			p := payload
+			if i < len(subs)-1 {
+				p = clone(payload)
+			}
+			sub.deliver(p, mode == Reliable)
+		}
+	}
+}
+
+// a subscriber too slow to keep up holds back reliable messages,
+// for every subscription of the guest, and misses best effort ones
+func (sub *Subscription) deliver(payload []byte, reliable bool) {
+	if reliable {
+		select {
+		case sub.messages <- payload:
+		case <-sub.closed:
+		}
+		return
+	}
+	select {
+	case sub.messages <- payload:
+	default:
+		slog.Debug("subscription queue full, dropping message", "topic", sub.topic)
+	}
+}
+
+// Topic the subscription receives
+func (sub *Subscription) Topic() string { return sub.topic }
+
+// ReadMessage returns the next message published to the topic.
+func (sub *Subscription) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-sub.messages:
+		return msg, nil
+	case <-sub.closed:
+		return nil, ErrUnsubscribed
+	case <-sub.guest.conn.Done():
+		return nil, sub.guest.conn.err
+	}
+}
+
+// Close the subscription. The owner stops sending the topic
+// once the last subscription to it is closed.
+func (sub *Subscription) Close() error {
+	var err error
+	sub.closeOnce.Do(func() {
+		close(sub.closed)
+		guest := sub.guest
+		guest.topicsMu.Lock()
+		subs := guest.topics[sub.topic]
+		for i, other := range subs {
+			if other == sub {
+				subs = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		if len(subs) == 0 {
+			delete(guest.topics, sub.topic)
+		} else {
+			guest.topics[sub.topic] = subs
+		}
+		guest.topicsMu.Unlock()
+		if len(subs) == 0 {
+			_, err = guest.conn.Channel(channelTopics, Reliable).Write(topicMessage(topicUnsubscribe, sub.topic, nil))
+		}
+	})
+	return err
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTopicMessage(t *testing.T) {
+	msg := topicMessage(topicPublish, "world", []byte("state"))
+	op, topic, payload, ok := parseTopicMessage(msg)
+	if !ok || op != topicPublish || topic != "world" || string(payload) != "state" {
+		t.Errorf("got %d %q %q %v", op, topic, payload, ok)
+	}
+	if _, _, _, ok := parseTopicMessage(msg[:4]); ok {
+		t.Error("truncated topic accepted")
+	}
+}
+
+// an owner and a guest whose sessions talk over a datagram pipe
+func topicPair(t *testing.T) (*Owner, *Guest, *datagramEnd) {
+	a, b, ab, _ := sessionPair(t, DefaultConfig("", ""))
+	owner := &Owner{sessions: map[uuid.UUID]*session{a.id: a}}
+	go owner.serveTopics(a)
+	guest := &Guest{conn: Conn{b}, topics: map[string][]*Subscription{}}
+	return owner, guest, ab
+}
+
+// wait until the owner knows how many guests subscribed to topic
+func waitSubscribers(t *testing.T, owner *Owner, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(owner.subscribers(topic)) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%q has %d subscribers, want %d", topic, len(owner.subscribers(topic)), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readSubscription(t *testing.T, sub *Subscription) []byte {
+	t.Helper()
+	select {
+	case msg := <-sub.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("nothing published to %q arrived", sub.topic)
+		return nil
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	owner, guest, _ := topicPair(t)
+	first, err := guest.Subscribe("world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := guest.Subscribe("world")
+	other, _ := guest.Subscribe("chat")
+	waitSubscribers(t, owner, "world", 1)
+	waitSubscribers(t, owner, "chat", 1)
+
+	owner.PublishReliable("world", []byte("reliable"))
+	owner.Publish("world", []byte("unreliable"))
+	owner.Publish("chat", []byte("hi"))
+	for _, sub := range []*Subscription{first, second} {
+		// both modes have their own channel, so either can arrive first
+		got := map[string]bool{}
+		got[string(readSubscription(t, sub))] = true
+		got[string(readSubscription(t, sub))] = true
+		if !got["reliable"] || !got["unreliable"] {
+			t.Errorf("got %v", got)
+		}
+	}
+	if msg := readSubscription(t, other); string(msg) != "hi" {
+		t.Errorf("chat got %q", msg)
+	}
+
+	// subscriptions don't share buffers
+	owner.PublishReliable("world", []byte("state"))
+	a, b := readSubscription(t, first), readSubscription(t, second)
+	a[0] = 'X'
+	if string(b) != "state" {
+		t.Errorf("changing one subscription's message changed another's to %q", b)
+	}
+
+	// the owner keeps sending until the last subscription is closed
+	first.Close()
+	if _, err := first.ReadMessage(); err != ErrUnsubscribed {
+		t.Errorf("closed subscription returned %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	waitSubscribers(t, owner, "world", 1)
+	second.Close()
+	waitSubscribers(t, owner, "world", 0)
+	waitSubscribers(t, owner, "chat", 1)
+}
+
+func TestPublishOverLossyLink(t *testing.T) {
+	owner, guest, ab := topicPair(t)
+	sub, _ := guest.Subscribe("world")
+	waitSubscribers(t, owner, "world", 1)
+	ab.set(func(e *datagramEnd) { e.dropEvery = 3 })
+	for i := range 30 {
+		owner.PublishReliable("world", []byte{'r', byte(i)})
+		owner.Publish("world", []byte{'u', byte(i)})
+	}
+	reliable, unreliable := 0, 0
+	for reliable < 30 {
+		msg := readSubscription(t, sub)
+		switch msg[0] {
+		case 'r':
+			if msg[1] != byte(reliable) {
+				t.Fatalf("reliable message %d arrived as %d", msg[1], reliable)
+			}
+			reliable++
+		case 'u':
+			unreliable++
+		}
+	}
+	if unreliable >= 30 {
+		t.Errorf("all %d unreliable messages arrived over a lossy link", unreliable)
+	}
+}
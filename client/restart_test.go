@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// restart ice on the guest and wait until the owner answered
+func restartIce(t *testing.T, guest *Guest) {
+	t.Helper()
+	_, pc := guest.current()
+	sent, err := guest.restartIce(context.Background(), pc)
+	if err != nil || !sent {
+		t.Fatalf("restart not sent: %v", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); guest.restarting.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatal("the owner did not answer the restart")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// the owner's message still reaches the guest
+func expectDelivered(t *testing.T, conn Conn, guest *Guest) {
+	t.Helper()
+	if _, err := conn.WriteReliable([]byte("after restart")); err != nil {
+		t.Fatal(err)
+	}
+	guest.Conn().SetReadDeadline(time.Now().Add(20 * time.Second))
+	p := make([]byte, 64)
+	n, err := guest.Conn().Read(p)
+	if err != nil || string(p[:n]) != "after restart" {
+		t.Fatalf("got %q, %v", p[:n], err)
+	}
+}
+
+func TestIceRestart(t *testing.T) {
+	var guestLog eventLog
+	_, guest, conn := joinRoom(t, nil, func(c *Config) { c.OnEvent = guestLog.add })
+	guestLog.waitFor(t, EventConnected)
+	_, pc := guest.current()
+
+	restartIce(t, guest)
+	expectDelivered(t, conn, guest)
+	if _, current := guest.current(); current != pc {
+		t.Error("the guest reconnected instead of restarting ice")
+	}
+}
+
+func TestIceRestartRejected(t *testing.T) {
+	var guestLog eventLog
+	owner, guest, conn := joinRoom(t, nil, func(c *Config) { c.OnEvent = guestLog.add })
+	guestLog.waitFor(t, EventConnected)
+	_, pc := guest.current()
+	// the owner lost the guest's agent, so it can not restart it
+	owner.deleteConnection(conn.peerID())
+
+	restartIce(t, guest)
+	// the guest resumes its session over a new connection instead
+	expectDelivered(t, conn, guest)
+	if _, current := guest.current(); current == pc {
+		t.Error("the guest kept the connection the owner could not restart")
+	}
+	if len(owner.Peers()) != 1 {
+		t.Errorf("the owner has %d peers, want 1", len(owner.Peers()))
+	}
+}
@@ -0,0 +1,482 @@
+package client
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/ice/v4"
+)
+
+// every datagram sent over the peer to peer path starts with its kind.
+// data packets and acks are followed by the channel they belong to.
+const (
+	kindUnreliable        byte = iota + 1
+	kindReliable               // followed by a uint32 sequence number
+	kindAck                    // followed by the next expected sequence number
+	kindReliableUnordered      // followed by a uint32 sequence number
+	kindProbe                  // path mtu probe, followed by a uint32 id and padding
+	kindProbeAck               // followed by the probe id and its uint16 size
+	kindTransport              // data packet, followed by a uint32 sequence number and send time
+	kindFeedback               // what arrived of the paced packets
+	kindProtected              // unreliable packet protected by fec, followed by its group and index
+	kindParity                 // xor of a group of protected packets
+	kindSequenced              // followed by a uint32 sequence number
+	kindClockRequest           // followed by the int64 unix nano time it was sent
+	kindClockResponse          // followed by the request time, and when it was received and answered
+	kindPing                   // keepalive, followed by a uint32 id
+	kindPong                   // followed by the id of the ping it answers
+	kindClose                  // the session is over, followed by why
+)
+
+const (
+	// largest datagram the ice agent hands to us
+	receiveMTU = 8192
+	// resend reliable data that was not acknowledged in time
+	retransmitTimeout = 200 * time.Millisecond
+	// amount of unacknowledged reliable messages before writes block
+	reliableWindow = 1024
+	// received messages waiting for Read
+	incomingQueueSize = 256
+)
+
+// a session is the logical connection between owner and guest.
+// it outlives the ice connection it runs on, when the guest reconnects
+// the session is bound to the new transport and unacknowledged
+// reliable data is sent again.
+type session struct {
+	id  uuid.UUID
+	cfg Config
+
+	mu sync.Mutex
+	// the ice connection currently carrying traffic,
+	// and the peer connection it belongs to
+	transport net.Conn
+	pc        *peerConnection
+	// extra candidate pairs data is duplicated over, see Config.Multipath
+	paths []*ice.CandidatePair
+	// id the signaling server gave to the remote peer.
+	// it changes when the guest reconnects.
+	peer uuid.UUID
+	// closes the session if it is not resumed in time
+	expiry *time.Timer
+
+	channels map[uint8]*Channel
+	// channel 0, used by Read and Write
+	main *Channel
+	// topics the guest subscribed to, only tracked by the owner
+	topics map[string]bool
+	// what the guest told about itself when joining
+	metadata map[string]string
+	// the guest proves with it that it is resuming its own session, only set on the owner
+	secret string
+	// why the session is going away, if known before it closes
+	reason DisconnectReason
+
+	pmtu pathMTU
+	// nil unless both peers agreed to compress
+	compression *compressor
+
+	// start of the session clock
+	epoch time.Time
+	// when the current ice connection was established, zero while detached. guarded by mu
+	connectedAt time.Time
+	// congestion control and bandwidth estimation, nil pacer without pacing
+	cc       congestion
+	pacer    *pacer
+	arrivals arrivals
+	// packets rebuilt by forward error correction
+	fecRecovered atomic.Uint64
+	// datagrams over every transport the session ran on
+	packetsSent, packetsReceived atomic.Uint64
+	bytesSent, bytesReceived     atomic.Uint64
+	// offset to the owner's clock
+	serverClock clockSync
+	keepalive   keepalive
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+func newSession(id, peer uuid.UUID, pc *peerConnection, transport net.Conn, cfg Config, compression *compressor) *session {
+	s := &session{
+		id:          id,
+		cfg:         cfg,
+		peer:        peer,
+		transport:   transport,
+		pc:          pc,
+		compression: compression,
+		channels:    map[uint8]*Channel{},
+		topics:      map[string]bool{},
+		closed:      make(chan struct{}),
+		epoch:       time.Now(),
+		connectedAt: time.Now(),
+		arrivals:    arrivals{reported: true},
+		keepalive:   keepalive{lastSeen: time.Now()},
+		cc: congestion{
+			estimator: NewDelayBasedController(initialBandwidth, minBandwidth, maxBandwidth),
+		},
+	}
+	s.main = s.channel(0, Unreliable)
+	if cfg.CongestionControl != nil {
+		s.cc.controller = cfg.CongestionControl()
+		s.pacer = newPacer(s)
+		go s.pacer.loop()
+	}
+	go s.readLoop(transport)
+	go s.retransmitLoop()
+	go s.pmtuLoop()
+	go s.feedbackLoop()
+	go s.keepaliveLoop()
+	if cfg.Multipath > 1 {
+		go s.multipathLoop()
+	}
+	return s
+}
+
+// ID of the session. It stays the same for the lifetime of a player session,
+// even when the guest reconnects.
+func (s *session) ID() uuid.UUID { return s.id }
+
+// id the signaling server currently uses for the remote peer
+func (s *session) peerID() uuid.UUID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peer
+}
+
+// Channel returns the channel with this id, opening it if needed.
+// Messages written to it are delivered according to mode,
+// calling Channel again changes the mode of an open channel.
+// Both peers can use the same id, the remote side receives on it
+// no matter which mode the sender picked.
+// Ids from 250 up are used by the library itself.
+func (s *session) Channel(id uint8, mode Mode) *Channel {
+	ch := s.channel(id, mode)
+	ch.mu.Lock()
+	ch.mode = mode
+	ch.mu.Unlock()
+	return ch
+}
+
+// get or open a channel.
+// channels are opened implicitly when the remote sends on them.
+func (s *session) channel(id uint8, mode Mode) *Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[id]
+	if !ok {
+		ch = newChannel(s, id, mode)
+		s.channels[id] = ch
+	}
+	return ch
+}
+
+func (s *session) allChannels() []*Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels := make([]*Channel, 0, len(s.channels))
+	for _, ch := range s.channels {
+		channels = append(channels, ch)
+	}
+	return channels
+}
+
+// rebind moves the session to a new ice connection, and sends
+// everything that was not acknowledged on the old one again.
+func (s *session) rebind(peer uuid.UUID, pc *peerConnection, transport net.Conn) {
+	s.mu.Lock()
+	old := s.transport
+	s.transport = transport
+	s.pc, s.paths = pc, nil
+	s.peer = peer
+	s.connectedAt = time.Now()
+	// it came back
+	s.reason = 0
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.mu.Unlock()
+
+	if old != nil && old != transport {
+		old.Close()
+	}
+	// don't count the time it was detached as silence
+	s.seen(time.Now())
+	go s.readLoop(transport)
+	replayed := 0
+	for _, ch := range s.allChannels() {
+		for _, packet := range ch.expired(time.Now(), 0) {
+			s.sendData(ch, packet, time.Time{})
+			replayed++
+		}
+	}
+	slog.Debug("session resumed", "session", s.id, "replayed", replayed)
+}
+
+// detach is called when the transport is lost for good.
+// the session is closed if it is not resumed within timeout.
+func (s *session) detach(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transport != nil {
+		s.transport.Close()
+		s.transport = nil
+	}
+	s.pc, s.paths = nil, nil
+	s.connectedAt = time.Time{}
+	if s.expiry != nil {
+		return
+	}
+	s.expiry = time.AfterFunc(timeout, func() {
+		s.close(ErrSessionExpired)
+	})
+}
+
+func (s *session) readLoop(transport net.Conn) {
+	buf := make([]byte, receiveMTU)
+	for {
+		n, err := transport.Read(buf)
+		if err != nil {
+			// the transport was replaced or the session closed
+			return
+		}
+		s.packetsReceived.Add(1)
+		s.bytesReceived.Add(uint64(n))
+		s.handlePacket(buf[:n])
+	}
+}
+
+func (s *session) handlePacket(packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+	s.seen(time.Now())
+	switch packet[0] {
+	case kindProbe:
+		s.receiveProbe(packet)
+		return
+	case kindProbeAck:
+		s.receiveProbeAck(packet)
+		return
+	case kindTransport:
+		s.receiveTransport(packet)
+		return
+	case kindFeedback:
+		s.receiveFeedback(packet)
+		return
+	case kindClockRequest:
+		s.receiveClockRequest(packet)
+		return
+	case kindClockResponse:
+		s.receiveClockResponse(packet)
+		return
+	case kindPing:
+		s.receivePing(packet)
+		return
+	case kindPong:
+		s.receivePong(packet)
+		return
+	case kindClose:
+		s.receiveClose(packet)
+		return
+	}
+	if len(packet) < 2 {
+		return
+	}
+	kind, body := packet[0], packet[2:]
+	ch := s.channel(packet[1], Unreliable)
+	select {
+	case <-ch.closed:
+		return
+	default:
+	}
+	switch kind {
+	case kindUnreliable:
+		ch.receiveUnreliable(body)
+	case kindProtected:
+		ch.receiveProtected(body)
+	case kindParity:
+		ch.receiveParity(body)
+	case kindSequenced:
+		if len(body) < 4 {
+			return
+		}
+		ch.receiveSequenced(binary.BigEndian.Uint32(body), body[4:])
+	case kindReliable, kindReliableUnordered:
+		if len(body) < 4 {
+			return
+		}
+		ch.receiveReliable(kind == kindReliable, binary.BigEndian.Uint32(body), body[4:])
+	case kindAck:
+		if len(body) < 4 {
+			return
+		}
+		ch.receiveAck(binary.BigEndian.Uint32(body))
+	default:
+		slog.Debug("unknown packet kind", "session", s.id, "kind", kind)
+	}
+}
+
+func (s *session) retransmitLoop() {
+	ticker := time.NewTicker(retransmitTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			for _, ch := range s.allChannels() {
+				for _, packet := range ch.expired(now, retransmitTimeout) {
+					s.sendData(ch, packet, time.Time{})
+				}
+				if parity := ch.flushFEC(now); parity != nil {
+					s.sendData(ch, parity, time.Time{})
+				}
+			}
+		}
+	}
+}
+
+// bytes data packets grow by on their way to the transport
+func (s *session) overhead() int {
+	return transportHeaderSize
+}
+
+// send a data packet of a channel, paced by the congestion controller if there is one.
+// it is dropped if it can't be sent before deadline.
+func (s *session) sendData(ch *Channel, packet []byte, deadline time.Time) error {
+	if s.pacer == nil {
+		if ch.late(deadline, time.Now()) {
+			return nil
+		}
+		return s.sendTransport(packet)
+	}
+	select {
+	case <-s.closed:
+		return s.err
+	default:
+	}
+	s.pacer.enqueue(pacedPacket{packet: packet, ch: ch, deadline: deadline})
+	return nil
+}
+
+// write a packet to the current transport.
+// packets are dropped while the session waits to be resumed.
+func (s *session) send(packet []byte) error {
+	s.mu.Lock()
+	transport := s.transport
+	s.mu.Unlock()
+	select {
+	case <-s.closed:
+		return s.err
+	default:
+	}
+	if transport == nil {
+		return nil
+	}
+	n, err := transport.Write(packet)
+	s.packetsSent.Add(1)
+	s.bytesSent.Add(uint64(n))
+	return err
+}
+
+// Read a message from channel 0. Unreliable and reliable messages
+// are returned in the order they arrive.
+func (s *session) Read(p []byte) (int, error) {
+	return s.main.Read(p)
+}
+
+// ReadMessage returns the next message from channel 0, however large it is.
+func (s *session) ReadMessage() ([]byte, error) {
+	return s.main.ReadMessage()
+}
+
+// Write sends p unreliably on channel 0, it may be lost or arrive out of order.
+func (s *session) Write(p []byte) (int, error) {
+	return s.main.writeUnreliable(p, time.Time{})
+}
+
+// WriteBefore sends p like Write, but drops it if it could not be sent before deadline.
+// See [Channel.WriteBefore].
+func (s *session) WriteBefore(p []byte, deadline time.Time) (int, error) {
+	return s.main.writeUnreliable(p, deadline)
+}
+
+// WriteReliable sends p on channel 0 so that it arrives exactly once,
+// and in order with other reliable messages. It is sent again when the guest reconnects.
+// WriteReliable blocks while too many messages are waiting for acknowledgement.
+func (s *session) WriteReliable(p []byte) (int, error) {
+	return s.main.writeReliable(kindReliable, p)
+}
+
+func (s *session) close(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		close(s.closed)
+		if s.transport != nil {
+			s.transport.Close()
+		}
+		if s.expiry != nil {
+			s.expiry.Stop()
+		}
+		s.mu.Unlock()
+		// wake up writers waiting for acknowledgements
+		for _, ch := range s.allChannels() {
+			ch.mu.Lock()
+			ch.acked.Broadcast()
+			ch.mu.Unlock()
+		}
+	})
+}
+
+// Done is closed when the session is closed.
+func (s *session) Done() <-chan struct{} { return s.closed }
+
+// LocalAddr returns the local address of the selected candidate pair, or nil.
+func (s *session) LocalAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transport == nil {
+		return nil
+	}
+	return s.transport.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the selected candidate pair, or nil.
+func (s *session) RemoteAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transport == nil {
+		return nil
+	}
+	return s.transport.RemoteAddr()
+}
+
+func (s *session) SetDeadline(t time.Time) error {
+	return s.main.SetDeadline(t)
+}
+func (s *session) SetReadDeadline(t time.Time) error {
+	return s.main.SetReadDeadline(t)
+}
+
+// writes never block on the network, so there is nothing to time out
+func (s *session) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// a < b, taking wraparound into account
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
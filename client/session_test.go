@@ -0,0 +1,71 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReliableOverLossyLink(t *testing.T) {
+	a, b, ab, ba := sessionPair(t, DefaultConfig("", ""))
+	for _, end := range []*datagramEnd{ab, ba} {
+		end.set(func(e *datagramEnd) { e.dropEvery, e.reorder = 4, true })
+	}
+	for i := range 200 {
+		if _, err := a.WriteReliable(fmt.Appendf(nil, "message %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, msg := range readMessages(t, b.main, 200) {
+		if want := fmt.Sprintf("message %d", i); string(msg) != want {
+			t.Fatalf("got %q, want %q", msg, want)
+		}
+	}
+	expectNoMessage(t, b.main)
+}
+
+func TestResumeReplaysUnacked(t *testing.T) {
+	a, b, ab, _ := sessionPair(t, DefaultConfig("", ""))
+	for i := range 10 {
+		a.WriteReliable(fmt.Appendf(nil, "message %d", i))
+	}
+	readMessages(t, b.main, 10)
+
+	// the path dies mid stream, nothing written now arrives
+	ab.set(func(e *datagramEnd) { e.down = true })
+	for i := 10; i < 50; i++ {
+		a.WriteReliable(fmt.Appendf(nil, "message %d", i))
+	}
+	a.detach(time.Minute)
+	b.detach(time.Minute)
+
+	// the guest comes back over a new path
+	ab, ba := datagramPipe()
+	a.rebind(uuid.UUID{}, nil, ab)
+	b.rebind(uuid.UUID{}, nil, ba)
+	for i, msg := range readMessages(t, b.main, 40) {
+		if want := fmt.Sprintf("message %d", i+10); string(msg) != want {
+			t.Fatalf("got %q, want %q", msg, want)
+		}
+	}
+	expectNoMessage(t, b.main)
+}
+
+func TestResumeExpiredSession(t *testing.T) {
+	owner, guest, conn := joinRoom(t, nil, nil)
+	// the owner gave up on the guest while it was away
+	owner.deleteSession(conn.ID())
+	conn.close(ErrSessionExpired)
+	ws, _ := guest.current()
+	ws.CloseNow()
+
+	guest.Conn().SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := guest.Conn().Read(make([]byte, 10)); err != ErrSessionExpired {
+		t.Errorf("got %v, want ErrSessionExpired", err)
+	}
+	if len(owner.Peers()) != 0 {
+		t.Error("the owner started a new session for the guest")
+	}
+}
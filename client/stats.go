@@ -0,0 +1,117 @@
+package client
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/ice/v4"
+)
+
+// Stats describes the path a [Conn] is sending over.
+type Stats struct {
+	// estimated bandwidth towards the remote, in bytes per second.
+	// the estimate follows the one-way delay and loss the remote reports,
+	// whether or not congestion control is enabled.
+	EstimatedBandwidth int
+	// rate the remote received data at, in bytes per second
+	ReceiveRate int
+	// fraction of packets lost in the latest report
+	LossRate float64
+	// slope of the one-way delay, positive while a queue builds up on the path
+	DelayGradient float64
+	// smoothed round trip time and how much it varies, see [Conn.RTT]
+	RTT    time.Duration
+	Jitter time.Duration
+	// fraction of the recent keepalive probes that weren't answered
+	KeepaliveLoss float64
+	// when anything last arrived from the remote
+	LastSeen time.Time
+	// lost packets that were rebuilt by forward error correction,
+	// see [Channel.SetFEC]
+	FECRecovered uint64
+
+	// both ends of the candidate pair ice selected. zero while
+	// waiting for the guest to reconnect
+	LocalCandidate, RemoteCandidate CandidateStats
+	// round trip time of the latest ice connectivity check on the pair
+	PairRTT time.Duration
+	// datagrams and their bytes over the peer to peer path,
+	// including reconnects
+	PacketsSent, PacketsReceived uint64
+	BytesSent, BytesReceived     uint64
+	// how long ago the current ice connection was established.
+	// it starts over when the guest reconnects, and is zero
+	// while waiting for it to. see [Peer.Joined] for the whole session
+	Uptime time.Duration
+}
+
+// CandidateStats describes one end of a candidate pair.
+type CandidateStats struct {
+	// host for a direct connection, srflx or prflx when going through a nat,
+	// relay through a turn server
+	Type    ice.CandidateType
+	Address string
+	Port    int
+}
+
+// Relayed tells if the connection goes through a turn server
+func (s Stats) Relayed() bool {
+	return s.LocalCandidate.Type == ice.CandidateTypeRelay || s.RemoteCandidate.Type == ice.CandidateTypeRelay
+}
+
+// Stats returns the latest measurements of the connection.
+func (s *session) Stats() Stats {
+	s.cc.mu.Lock()
+	fb := s.cc.feedback
+	s.cc.mu.Unlock()
+	k := &s.keepalive
+	k.mu.Lock()
+	rtt, jitter, lastSeen := k.rtt, k.jitter, k.lastSeen
+	k.mu.Unlock()
+	stats := Stats{
+		EstimatedBandwidth: s.cc.estimator.SendRate(),
+		ReceiveRate:        fb.ReceiveRate,
+		DelayGradient:      fb.DelayGradient,
+		RTT:                rtt,
+		Jitter:             jitter,
+		KeepaliveLoss:      k.lossRate(time.Now()),
+		LastSeen:           lastSeen,
+		FECRecovered:       s.fecRecovered.Load(),
+		PacketsSent:        s.packetsSent.Load(),
+		PacketsReceived:    s.packetsReceived.Load(),
+		BytesSent:          s.bytesSent.Load(),
+		BytesReceived:      s.bytesReceived.Load(),
+	}
+	s.mu.Lock()
+	pc := s.pc
+	if !s.connectedAt.IsZero() {
+		stats.Uptime = time.Since(s.connectedAt)
+	}
+	s.mu.Unlock()
+	if pc != nil {
+		stats.LocalCandidate, stats.RemoteCandidate, stats.PairRTT, _ = pc.SelectedPair()
+	}
+	// no probe was answered yet
+	if stats.RTT == 0 {
+		stats.RTT = fb.RTT
+	}
+	if fb.Expected > 0 {
+		stats.LossRate = float64(fb.Lost) / float64(fb.Expected)
+	}
+	return stats
+}
+
+// Stats of every guest connection, by [Conn.ID]
+func (owner *Owner) Stats() map[uuid.UUID]Stats {
+	owner.connMu.Lock()
+	sessions := make([]*session, 0, len(owner.sessions))
+	for _, s := range owner.sessions {
+		sessions = append(sessions, s)
+	}
+	owner.connMu.Unlock()
+	stats := make(map[uuid.UUID]Stats, len(sessions))
+	for _, s := range sessions {
+		stats[s.id] = s.Stats()
+	}
+	return stats
+}
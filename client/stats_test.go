@@ -0,0 +1,58 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/ice/v4"
+)
+
+func TestStats(t *testing.T) {
+	cfg := DefaultConfig("", "")
+	cfg.KeepaliveInterval = 20 * time.Millisecond
+	a, b, _, _ := sessionPair(t, cfg)
+	for range 20 {
+		a.WriteReliable(make([]byte, 100))
+	}
+	readMessages(t, b.main, 20)
+	time.Sleep(200 * time.Millisecond)
+
+	stats := a.Stats()
+	if stats.RTT <= 0 {
+		t.Errorf("rtt is %v after keepalives", stats.RTT)
+	}
+	if stats.PacketsSent < 20 || stats.BytesSent < 20*100 {
+		t.Errorf("sent %d packets, %d bytes", stats.PacketsSent, stats.BytesSent)
+	}
+	if remote := b.Stats(); remote.PacketsReceived < 20 || remote.BytesReceived < 20*100 {
+		t.Errorf("received %d packets, %d bytes", remote.PacketsReceived, remote.BytesReceived)
+	}
+	if stats.Uptime < 200*time.Millisecond {
+		t.Errorf("uptime is %v", stats.Uptime)
+	}
+	if time.Since(stats.LastSeen) > time.Second {
+		t.Errorf("last seen %v ago", time.Since(stats.LastSeen))
+	}
+	// no peer connection, so no candidate pair
+	if stats.LocalCandidate != (CandidateStats{}) || stats.Relayed() {
+		t.Errorf("candidate pair %+v", stats.LocalCandidate)
+	}
+	if !(Stats{RemoteCandidate: CandidateStats{Type: ice.CandidateTypeRelay}}).Relayed() {
+		t.Error("relayed remote candidate is not reported")
+	}
+}
+
+func TestUptimeAfterReconnect(t *testing.T) {
+	a, _, _, _ := sessionPair(t, DefaultConfig("", ""))
+	time.Sleep(200 * time.Millisecond)
+	a.detach(time.Minute)
+	if uptime := a.Stats().Uptime; uptime != 0 {
+		t.Errorf("uptime is %v while detached", uptime)
+	}
+	ab, _ := datagramPipe()
+	a.rebind(uuid.UUID{}, nil, ab)
+	if uptime := a.Stats().Uptime; uptime >= 200*time.Millisecond {
+		t.Errorf("uptime is %v right after reconnecting", uptime)
+	}
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// MessageConn is a connection that keeps message boundaries,
+// like [Conn] or a [Channel].
+type MessageConn interface {
+	Write(p []byte) (int, error)
+	ReadMessage() ([]byte, error)
+}
+
+// TypedConn sends and receives values of type T over a [MessageConn],
+// encoded with a [Codec].
+//
+// With a [Registry] every message is tagged with the type of the value,
+// so several types can share one connection. T is then usually an
+// interface the registered types implement, or any.
+type TypedConn[T any] struct {
+	conn     MessageConn
+	codec    Codec
+	registry *Registry
+}
+
+// NewTypedConn sends values of type T over conn.
+func NewTypedConn[T any](conn MessageConn, codec Codec) *TypedConn[T] {
+	return &TypedConn[T]{conn: conn, codec: codec}
+}
+
+// NewTaggedConn sends values of the types in registry over conn.
+func NewTaggedConn[T any](conn MessageConn, codec Codec, registry *Registry) *TypedConn[T] {
+	return &TypedConn[T]{conn: conn, codec: codec, registry: registry}
+}
+
+// Send v as one message.
+func (c *TypedConn[T]) Send(v T) error {
+	var msg []byte
+	if c.registry != nil {
+		tag, ok := c.registry.tag(reflect.TypeOf(v))
+		if !ok {
+			return fmt.Errorf("%w: %T", ErrUnregisteredType, v)
+		}
+		msg = binary.AppendUvarint(msg, uint64(tag))
+	}
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(append(msg, data...))
+	return err
+}
+
+// Recv the next value. Messages that fail to decode return an error,
+// the connection can still be used after that.
+func (c *TypedConn[T]) Recv() (v T, err error) {
+	msg, err := c.conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	if c.registry == nil {
+		typ := reflect.TypeFor[T]()
+		// pointer types like protobuf messages are decoded into a new value
+		if typ.Kind() == reflect.Pointer {
+			v = reflect.New(typ.Elem()).Interface().(T)
+			err = c.codec.Unmarshal(msg, v)
+			return
+		}
+		err = c.codec.Unmarshal(msg, &v)
+		return
+	}
+
+	tag, n := binary.Uvarint(msg)
+	if n <= 0 {
+		err = fmt.Errorf("%w: missing type tag", ErrUnregisteredType)
+		return
+	}
+	typ, ok := c.registry.typ(uint32(tag))
+	if !ok {
+		err = fmt.Errorf("%w: tag %d", ErrUnregisteredType, tag)
+		return
+	}
+	var ptr reflect.Value
+	if typ.Kind() == reflect.Pointer {
+		ptr = reflect.New(typ.Elem())
+		err = c.codec.Unmarshal(msg[n:], ptr.Interface())
+	} else {
+		ptr = reflect.New(typ)
+		err = c.codec.Unmarshal(msg[n:], ptr.Interface())
+		ptr = ptr.Elem()
+	}
+	if err != nil {
+		return
+	}
+	v, ok = ptr.Interface().(T)
+	if !ok {
+		err = fmt.Errorf("received %s, which is not a %s", typ, reflect.TypeFor[T]())
+	}
+	return
+}
+
+// Registry assigns tags to the types sent over a [TypedConn],
+// both peers must register the same types under the same tags.
+// It is safe to use from multiple goroutines.
+type Registry struct {
+	mu    sync.RWMutex
+	types map[uint32]reflect.Type
+	tags  map[reflect.Type]uint32
+}
+
+func NewRegistry() *Registry {
+	return &Registry{types: map[uint32]reflect.Type{}, tags: map[reflect.Type]uint32{}}
+}
+
+// Register T under tag. It panics if the tag or the type is already registered.
+// Pointer types are sent as what they point to, and received as a new pointer.
+func Register[T any](r *Registry, tag uint32) {
+	typ := reflect.TypeFor[T]()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if other, ok := r.types[tag]; ok {
+		panic(fmt.Sprintf("tag %d is already registered for %s", tag, other))
+	}
+	if other, ok := r.tags[typ]; ok {
+		panic(fmt.Sprintf("%s is already registered with tag %d", typ, other))
+	}
+	r.types[tag] = typ
+	r.tags[typ] = tag
+}
+
+func (r *Registry) tag(typ reflect.Type) (uint32, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tag, ok := r.tags[typ]
+	return tag, ok
+}
+
+func (r *Registry) typ(tag uint32) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	typ, ok := r.types[tag]
+	return typ, ok
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// loops messages back to the reader
+type loopback chan []byte
+
+func (l loopback) Write(p []byte) (int, error) {
+	l <- append([]byte(nil), p...)
+	return len(p), nil
+}
+func (l loopback) ReadMessage() ([]byte, error) { return <-l, nil }
+
+type move struct{ X, Y int }
+type chat struct{ Text string }
+
+func TestTypedConn(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSON, "msgpack": Msgpack, "gob": Gob} {
+		conn := NewTypedConn[move](make(loopback, 1), codec)
+		if err := conn.Send(move{1, 2}); err != nil {
+			t.Fatal(name, err)
+		}
+		if got, err := conn.Recv(); err != nil || got != (move{1, 2}) {
+			t.Errorf("%s: got %v, %v", name, got, err)
+		}
+	}
+
+	pb := NewTypedConn[*wrapperspb.StringValue](make(loopback, 1), Protobuf)
+	pb.Send(wrapperspb.String("hello"))
+	if got, err := pb.Recv(); err != nil || got.GetValue() != "hello" {
+		t.Errorf("protobuf: got %v, %v", got, err)
+	}
+}
+
+func TestTaggedConn(t *testing.T) {
+	r := NewRegistry()
+	Register[move](r, 1)
+	Register[*chat](r, 2)
+	conn := NewTaggedConn[any](make(loopback, 3), Msgpack, r)
+
+	conn.Send(move{3, 4})
+	conn.Send(&chat{"gg"})
+	if err := conn.Send("unregistered"); !errors.Is(err, ErrUnregisteredType) {
+		t.Errorf("sending an unregistered type returned %v", err)
+	}
+	if got, err := conn.Recv(); err != nil || got != (move{3, 4}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if got, err := conn.Recv(); err != nil || got.(*chat).Text != "gg" {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,83 @@
+package crdt
+
+// Counter can be added to by every peer at the same time.
+// Every peer keeps a tally of its own increments and decrements,
+// the value is the sum over all peers.
+type Counter struct {
+	doc     *Doc
+	name    string
+	tallies map[string]tally
+}
+
+type tally struct {
+	Inc, Dec uint64
+}
+
+type counterUpdate struct {
+	Name    string
+	Replica string
+	tally
+}
+
+// Counter returns the counter with this name, creating it if needed
+func (d *Doc) Counter(name string) *Counter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getCounter(name)
+}
+
+// must be called with d.mu held
+func (d *Doc) getCounter(name string) *Counter {
+	c, ok := d.counters[name]
+	if !ok {
+		c = &Counter{doc: d, name: name, tallies: map[string]tally{}}
+		d.counters[name] = c
+	}
+	return c
+}
+
+// Add n to the counter, n can be negative
+func (c *Counter) Add(n int64) {
+	c.doc.mu.Lock()
+	replica := c.doc.replica
+	t := c.tallies[replica]
+	if n >= 0 {
+		t.Inc += uint64(n)
+	} else {
+		t.Dec += uint64(-n)
+	}
+	c.tallies[replica] = t
+	c.doc.mu.Unlock()
+	c.doc.broadcast(message{Counters: []counterUpdate{{Name: c.name, Replica: replica, tally: t}}}, nil)
+}
+
+func (c *Counter) Value() int64 {
+	c.doc.mu.Lock()
+	defer c.doc.mu.Unlock()
+	var v int64
+	for _, t := range c.tallies {
+		v += int64(t.Inc) - int64(t.Dec)
+	}
+	return v
+}
+
+// tallies only grow, the larger one is the newer one.
+// must be called with doc.mu held
+func (c *Counter) merge(u counterUpdate) bool {
+	t := c.tallies[u.Replica]
+	merged := tally{Inc: max(t.Inc, u.Inc), Dec: max(t.Dec, u.Dec)}
+	if merged == t {
+		return false
+	}
+	c.tallies[u.Replica] = merged
+	return true
+}
+
+// must be called with doc.mu held
+func (c *Counter) state() []counterUpdate {
+	updates := make([]counterUpdate, 0, len(c.tallies))
+	for r, t := range c.tallies {
+		updates = append(updates, counterUpdate{Name: c.name, Replica: r, tally: t})
+	}
+	return updates
+}
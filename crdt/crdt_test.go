@@ -0,0 +1,196 @@
+package crdt
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BrownNPC/Ice-Data-Channel/internal/pipetest"
+)
+
+func connect(a, b *Doc) {
+	pa, pb := pipetest.Pipe(1024)
+	a.AddPeerOver(pa)
+	b.AddPeerOver(pb)
+}
+
+type snapshot struct {
+	Keys    []string
+	Values  map[string]string
+	Count   int64
+	Entries []string
+}
+
+func snapshotOf(d *Doc) snapshot {
+	s := snapshot{Keys: d.Map("m").Keys(), Values: map[string]string{}, Count: d.Counter("c").Value()}
+	for _, k := range s.Keys {
+		v, _ := d.Map("m").Get(k)
+		s.Values[k] = string(v)
+	}
+	for _, v := range d.Sequence("s").Values() {
+		s.Entries = append(s.Entries, string(v))
+	}
+	return s
+}
+
+// wait until every doc has the same state
+func converge(t *testing.T, docs ...*Doc) snapshot {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		want := snapshotOf(docs[0])
+		same := true
+		for _, d := range docs[1:] {
+			same = same && reflect.DeepEqual(snapshotOf(d), want)
+		}
+		if same {
+			return want
+		}
+		if time.Now().After(deadline) {
+			for _, d := range docs {
+				t.Log(snapshotOf(d))
+			}
+			t.Fatal("docs did not converge")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func edit(d *Doc, name string) {
+	for i := range 20 {
+		d.Map("m").Set(fmt.Sprint(i%5), []byte(name))
+		d.Counter("c").Add(int64(i%3 - 1))
+		d.Sequence("s").Insert(i%3, []byte(fmt.Sprint(name, i)))
+		if i%4 == 3 {
+			d.Sequence("s").Delete(0)
+			d.Map("m").Delete(fmt.Sprint(i % 5))
+		}
+	}
+}
+
+func TestStar(t *testing.T) {
+	owner, a, b := NewDoc(), NewDoc(), NewDoc()
+	// guests only connect to the owner, who relays
+	connect(owner, a)
+	connect(owner, b)
+	done := make(chan struct{})
+	for _, d := range []*Doc{owner, a, b} {
+		go func() {
+			edit(d, d.replica[:4])
+			done <- struct{}{}
+		}()
+	}
+	for range 3 {
+		<-done
+	}
+	s := converge(t, owner, a, b)
+	if len(s.Entries) != 60-15 {
+		t.Errorf("sequence has %d entries", len(s.Entries))
+	}
+	if s.Count != 3*-1 {
+		t.Errorf("counter is %d", s.Count)
+	}
+
+	// a late joiner catches up
+	late := NewDoc()
+	late.Sequence("s").Append([]byte("offline"))
+	changed := make(chan struct{}, 1024)
+	late.OnChange(func() { changed <- struct{}{} })
+	connect(b, late)
+	s = converge(t, owner, a, b, late)
+	if len(s.Entries) != 60-15+1 {
+		t.Errorf("the late joiner's edit did not make it, %d entries", len(s.Entries))
+	}
+	if len(changed) == 0 {
+		t.Error("OnChange not called")
+	}
+}
+
+func TestMesh(t *testing.T) {
+	docs := []*Doc{NewDoc(), NewDoc(), NewDoc()}
+	for i := range docs {
+		for j := i + 1; j < len(docs); j++ {
+			connect(docs[i], docs[j])
+		}
+	}
+	for _, d := range docs {
+		edit(d, d.replica[:4])
+	}
+	converge(t, docs...)
+}
+
+// changes merged in any order, and more than once, give the same state
+func TestMergeOrder(t *testing.T) {
+	a, b := NewDoc(), NewDoc()
+	edit(a, "a")
+	edit(b, "b")
+	a.mu.Lock()
+	updates := a.state()
+	a.mu.Unlock()
+	b.mu.Lock()
+	other := b.state()
+	b.mu.Unlock()
+	updates.Maps = append(updates.Maps, other.Maps...)
+	updates.Counters = append(updates.Counters, other.Counters...)
+	updates.Sequences = append(updates.Sequences, other.Sequences...)
+
+	var want snapshot
+	for i := range 10 {
+		d := NewDoc()
+		rand.Shuffle(len(updates.Maps), func(i, j int) { updates.Maps[i], updates.Maps[j] = updates.Maps[j], updates.Maps[i] })
+		rand.Shuffle(len(updates.Sequences), func(i, j int) {
+			updates.Sequences[i], updates.Sequences[j] = updates.Sequences[j], updates.Sequences[i]
+		})
+		// one update at a time, some twice
+		for _, u := range updates.Maps {
+			d.merge(message{Maps: []mapUpdate{u, u}})
+		}
+		for _, u := range updates.Counters {
+			d.merge(message{Counters: []counterUpdate{u}})
+		}
+		for _, u := range updates.Sequences {
+			d.merge(message{Sequences: []sequenceUpdate{u}})
+		}
+		got := snapshotOf(d)
+		if i == 0 {
+			want = got
+		} else if !reflect.DeepEqual(got, want) {
+			t.Fatalf("merge order changed the result\n%v\n%v", got, want)
+		}
+	}
+}
+
+func TestSequenceInsert(t *testing.T) {
+	d := NewDoc()
+	s := d.Sequence("s")
+	s.Append([]byte("b"))
+	s.Insert(0, []byte("a"))
+	s.Append([]byte("d"))
+	s.Insert(2, []byte("c"))
+	s.Delete(1)
+	var got []string
+	for _, v := range s.Values() {
+		got = append(got, string(v))
+	}
+	if fmt.Sprint(got) != "[a c d]" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestLargeState(t *testing.T) {
+	a, b := NewDoc(), NewDoc()
+	for i := range 200 {
+		a.Map("m").Set(fmt.Sprint(i), make([]byte, 1000))
+		a.Sequence("s").Insert(i, []byte(fmt.Sprint(i)))
+	}
+	a.Counter("c").Add(3)
+	// the state is ~200KB, each message has to stay under 16KB
+	pa, pb := pipetest.Pipe(1024)
+	a.AddPeerOver(pa.Limit(16 << 10))
+	b.AddPeerOver(pb.Limit(16 << 10))
+	if s := converge(t, a, b); len(s.Keys) != 200 || len(s.Entries) != 200 || s.Count != 3 {
+		t.Errorf("got %d keys, %d entries and a count of %d", len(s.Keys), len(s.Entries), s.Count)
+	}
+}
@@ -0,0 +1,255 @@
+// Package crdt keeps shared state in sync between the peers of a room,
+// without one of them being the authority over it.
+//
+// A [Doc] holds named maps, counters and sequences. Every peer changes its
+// own copy, and the changes are sent to the others. They are conflict free
+// replicated data types: copies that have seen the same changes end up the
+// same, no matter in which order the changes arrived.
+//
+//	doc := crdt.NewDoc()
+//	owner, err := client.NewOwner(ctx, doc.AddPeer, cfg)
+//	...
+//	doc.Map("players").Set(name, color)
+//
+// Peers send each other their full state when they are added,
+// so new peers catch up with what happened before they joined.
+// State too large for one message is split over several.
+// Changes that are new to a peer are passed on to its other peers,
+// so the owner relays between guests, and meshes converge too.
+package crdt
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/BrownNPC/Ice-Data-Channel/client"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Channel of a [client.Conn] that AddPeer syncs over
+const Channel uint8 = 250
+
+// Doc is a set of named CRDTs. It is safe to use from multiple goroutines.
+type Doc struct {
+	// tells apart the changes of different peers
+	replica string
+
+	mu sync.Mutex
+	// lamport clock, orders changes across peers
+	clock     uint64
+	maps      map[string]*Map
+	counters  map[string]*Counter
+	sequences map[string]*Sequence
+	peers     map[client.MessageConn]bool
+	onChange  func()
+}
+
+// stamp orders changes, later changes have higher stamps.
+// the replica breaks ties between concurrent changes.
+type stamp struct {
+	Time    uint64
+	Replica string
+}
+
+func (s stamp) less(o stamp) bool {
+	if s.Time != o.Time {
+		return s.Time < o.Time
+	}
+	return s.Replica < o.Replica
+}
+
+// changes sent between peers. a peer's full state is a message too
+type message struct {
+	_msgpack struct{} `msgpack:",omitempty"`
+
+	Maps      []mapUpdate
+	Counters  []counterUpdate
+	Sequences []sequenceUpdate
+}
+
+func (m *message) empty() bool {
+	return len(m.Maps) == 0 && len(m.Counters) == 0 && len(m.Sequences) == 0
+}
+
+// split m into two messages with half of the changes each.
+// ok is false if there is only one change
+func (m *message) split() (a, b message, ok bool) {
+	half := (len(m.Maps) + len(m.Counters) + len(m.Sequences)) / 2
+	if half == 0 {
+		return a, b, false
+	}
+	n := min(half, len(m.Maps))
+	a.Maps, b.Maps = m.Maps[:n], m.Maps[n:]
+	half -= n
+	n = min(half, len(m.Counters))
+	a.Counters, b.Counters = m.Counters[:n], m.Counters[n:]
+	half -= n
+	a.Sequences, b.Sequences = m.Sequences[:half], m.Sequences[half:]
+	return a, b, true
+}
+
+func NewDoc() *Doc {
+	return &Doc{
+		replica:   uuid.NewString(),
+		maps:      map[string]*Map{},
+		counters:  map[string]*Counter{},
+		sequences: map[string]*Sequence{},
+		peers:     map[client.MessageConn]bool{},
+	}
+}
+
+// OnChange calls f after changes from a peer were merged.
+// It is not called for local changes. f must not block.
+func (d *Doc) OnChange(f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onChange = f
+}
+
+// AddPeer syncs with the remote peer of conn, over channel [Channel].
+// Both sides send their whole state first, so a peer joining late catches up.
+func (d *Doc) AddPeer(conn client.Conn) {
+	d.AddPeerOver(conn.Channel(Channel, client.ReliableUnordered))
+}
+
+// AddPeerOver syncs over conn, until reading from it fails.
+// conn must deliver every message, in any order.
+func (d *Doc) AddPeerOver(conn client.MessageConn) {
+	d.mu.Lock()
+	d.peers[conn] = true
+	full := d.state()
+	d.mu.Unlock()
+	if !full.empty() {
+		send(conn, full)
+	}
+	go d.readLoop(conn)
+}
+
+// next stamp for a local change. must be called with d.mu held
+func (d *Doc) tick() stamp {
+	d.clock++
+	return stamp{Time: d.clock, Replica: d.replica}
+}
+
+// keep the clock ahead of every change seen. must be called with d.mu held
+func (d *Doc) observe(s stamp) {
+	d.clock = max(d.clock, s.Time)
+}
+
+// everything in the doc. must be called with d.mu held
+func (d *Doc) state() message {
+	var msg message
+	for _, m := range d.maps {
+		msg.Maps = append(msg.Maps, m.state()...)
+	}
+	for _, c := range d.counters {
+		msg.Counters = append(msg.Counters, c.state()...)
+	}
+	for _, s := range d.sequences {
+		msg.Sequences = append(msg.Sequences, s.state()...)
+	}
+	return msg
+}
+
+// send a change to every peer but from
+func (d *Doc) broadcast(msg message, from client.MessageConn) {
+	d.mu.Lock()
+	peers := make([]client.MessageConn, 0, len(d.peers))
+	for p := range d.peers {
+		if p != from {
+			peers = append(peers, p)
+		}
+	}
+	d.mu.Unlock()
+	for _, p := range peers {
+		send(p, msg)
+	}
+}
+
+func send(conn client.MessageConn, msg message) {
+	err := write(conn, msg)
+	switch {
+	// a single change is larger than Config.MaxMessageSize
+	case errors.Is(err, client.ErrMessageTooLarge):
+		slog.Error("crdt change too large to send", "error", err)
+	case err != nil:
+		slog.Debug("failed to send crdt changes", "error", err)
+	}
+}
+
+// write msg to conn, split over as many messages as it takes
+// to fit the largest message conn can send. the changes merge in any order
+func write(conn client.MessageConn, msg message) error {
+	b, err := msgpack.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	if !errors.Is(err, client.ErrMessageTooLarge) {
+		return err
+	}
+	first, second, ok := msg.split()
+	if !ok {
+		return err
+	}
+	if err := write(conn, first); err != nil {
+		return err
+	}
+	return write(conn, second)
+}
+
+func (d *Doc) readLoop(conn client.MessageConn) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.peers, conn)
+		d.mu.Unlock()
+	}()
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg message
+		if msgpack.Unmarshal(b, &msg) != nil {
+			continue
+		}
+		changed := d.merge(msg)
+		if changed.empty() {
+			continue
+		}
+		d.broadcast(changed, conn)
+		d.mu.Lock()
+		f := d.onChange
+		d.mu.Unlock()
+		if f != nil {
+			f()
+		}
+	}
+}
+
+// merge the changes of a peer, returning those that were new
+func (d *Doc) merge(msg message) message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var changed message
+	for _, u := range msg.Maps {
+		d.observe(u.Time)
+		if d.getMap(u.Name).merge(u) {
+			changed.Maps = append(changed.Maps, u)
+		}
+	}
+	for _, u := range msg.Counters {
+		if d.getCounter(u.Name).merge(u) {
+			changed.Counters = append(changed.Counters, u)
+		}
+	}
+	for _, u := range msg.Sequences {
+		d.observe(u.ID)
+		if d.getSequence(u.Name).merge(u) {
+			changed.Sequences = append(changed.Sequences, u)
+		}
+	}
+	return changed
+}
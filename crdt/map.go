@@ -0,0 +1,101 @@
+package crdt
+
+import "sort"
+
+// Map is a last writer wins map. When peers set the same key at the
+// same time, the value with the higher stamp wins on every peer.
+type Map struct {
+	doc     *Doc
+	name    string
+	entries map[string]mapEntry
+}
+
+type mapEntry struct {
+	Value   []byte
+	Time    stamp
+	Deleted bool
+}
+
+type mapUpdate struct {
+	Name string
+	Key  string
+	mapEntry
+}
+
+// Map returns the map with this name, creating it if needed
+func (d *Doc) Map(name string) *Map {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getMap(name)
+}
+
+// must be called with d.mu held
+func (d *Doc) getMap(name string) *Map {
+	m, ok := d.maps[name]
+	if !ok {
+		m = &Map{doc: d, name: name, entries: map[string]mapEntry{}}
+		d.maps[name] = m
+	}
+	return m
+}
+
+// Get the value of key
+func (m *Map) Get(key string) ([]byte, bool) {
+	m.doc.mu.Lock()
+	defer m.doc.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// Set key to value. value must not be modified afterwards
+func (m *Map) Set(key string, value []byte) {
+	m.set(key, mapEntry{Value: value})
+}
+
+// Delete key
+func (m *Map) Delete(key string) {
+	m.set(key, mapEntry{Deleted: true})
+}
+
+func (m *Map) set(key string, e mapEntry) {
+	m.doc.mu.Lock()
+	e.Time = m.doc.tick()
+	m.entries[key] = e
+	m.doc.mu.Unlock()
+	m.doc.broadcast(message{Maps: []mapUpdate{{Name: m.name, Key: key, mapEntry: e}}}, nil)
+}
+
+// Keys that are set, sorted
+func (m *Map) Keys() []string {
+	m.doc.mu.Lock()
+	defer m.doc.mu.Unlock()
+	keys := make([]string, 0, len(m.entries))
+	for k, e := range m.entries {
+		if !e.Deleted {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// must be called with doc.mu held
+func (m *Map) merge(u mapUpdate) bool {
+	if e, ok := m.entries[u.Key]; ok && !e.Time.less(u.Time) {
+		return false
+	}
+	m.entries[u.Key] = u.mapEntry
+	return true
+}
+
+// must be called with doc.mu held
+func (m *Map) state() []mapUpdate {
+	updates := make([]mapUpdate, 0, len(m.entries))
+	for k, e := range m.entries {
+		updates = append(updates, mapUpdate{Name: m.name, Key: k, mapEntry: e})
+	}
+	return updates
+}
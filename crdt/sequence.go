@@ -0,0 +1,146 @@
+package crdt
+
+import "sort"
+
+// Sequence is an ordered list that peers can insert into at the same time.
+// It is a replicated growable array: every element remembers the element
+// it was inserted after, and elements inserted after the same one are
+// ordered by their stamps, newest first. Deleted elements are kept
+// as tombstones, so that later inserts can still refer to them.
+type Sequence struct {
+	doc      *Doc
+	name     string
+	elements map[stamp]*element
+}
+
+type element struct {
+	ID stamp
+	// element it was inserted after, the zero stamp for the start
+	After   stamp
+	Value   []byte
+	Deleted bool
+}
+
+type sequenceUpdate struct {
+	Name string
+	element
+}
+
+// Sequence returns the sequence with this name, creating it if needed
+func (d *Doc) Sequence(name string) *Sequence {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getSequence(name)
+}
+
+// must be called with d.mu held
+func (d *Doc) getSequence(name string) *Sequence {
+	s, ok := d.sequences[name]
+	if !ok {
+		s = &Sequence{doc: d, name: name, elements: map[stamp]*element{}}
+		d.sequences[name] = s
+	}
+	return s
+}
+
+// Insert value at index, moving the element there and the ones after it back.
+// An index past the end appends. value must not be modified afterwards
+func (s *Sequence) Insert(index int, value []byte) {
+	s.doc.mu.Lock()
+	visible := s.visible()
+	e := &element{ID: s.doc.tick(), Value: value}
+	if index = min(index, len(visible)); index > 0 {
+		e.After = visible[index-1].ID
+	}
+	s.elements[e.ID] = e
+	s.doc.mu.Unlock()
+	s.doc.broadcast(message{Sequences: []sequenceUpdate{{Name: s.name, element: *e}}}, nil)
+}
+
+// Append value to the end
+func (s *Sequence) Append(value []byte) {
+	s.Insert(int(^uint(0)>>1), value)
+}
+
+// Delete the element at index, it panics if index is out of range
+func (s *Sequence) Delete(index int) {
+	s.doc.mu.Lock()
+	e := s.visible()[index]
+	e.Deleted = true
+	update := sequenceUpdate{Name: s.name, element: *e}
+	s.doc.mu.Unlock()
+	s.doc.broadcast(message{Sequences: []sequenceUpdate{update}}, nil)
+}
+
+// Values in order
+func (s *Sequence) Values() [][]byte {
+	s.doc.mu.Lock()
+	defer s.doc.mu.Unlock()
+	visible := s.visible()
+	values := make([][]byte, len(visible))
+	for i, e := range visible {
+		values[i] = e.Value
+	}
+	return values
+}
+
+func (s *Sequence) Len() int {
+	s.doc.mu.Lock()
+	defer s.doc.mu.Unlock()
+	return len(s.visible())
+}
+
+// elements that are not deleted, in order. must be called with doc.mu held
+func (s *Sequence) visible() []*element {
+	children := map[stamp][]*element{}
+	for _, e := range s.elements {
+		children[e.After] = append(children[e.After], e)
+	}
+	for _, c := range children {
+		sort.Slice(c, func(i, j int) bool { return c[j].ID.less(c[i].ID) })
+	}
+	var visible []*element
+	// depth first from the start, an element is followed by what was inserted after it
+	stack := []*element{}
+	push := func(after stamp) {
+		c := children[after]
+		for i := len(c) - 1; i >= 0; i-- {
+			stack = append(stack, c[i])
+		}
+	}
+	push(stamp{})
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !e.Deleted {
+			visible = append(visible, e)
+		}
+		push(e.ID)
+	}
+	return visible
+}
+
+// elements are never changed, except for being deleted.
+// must be called with doc.mu held
+func (s *Sequence) merge(u sequenceUpdate) bool {
+	e, ok := s.elements[u.ID]
+	if !ok {
+		e := u.element
+		s.elements[e.ID] = &e
+		return true
+	}
+	if u.Deleted && !e.Deleted {
+		e.Deleted = true
+		return true
+	}
+	return false
+}
+
+// must be called with doc.mu held
+func (s *Sequence) state() []sequenceUpdate {
+	updates := make([]sequenceUpdate, 0, len(s.elements))
+	for _, e := range s.elements {
+		updates = append(updates, sequenceUpdate{Name: s.name, element: *e})
+	}
+	return updates
+}
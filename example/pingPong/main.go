@@ -9,8 +9,6 @@ import (
 	"net"
 	"os"
 	"time"
-
-	"encoding/json"
 )
 
 func main() {
@@ -40,13 +38,13 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	times := client.NewTypedConn[time.Time](guest.Conn(), client.JSON)
 	for range 5 {
 		time.Sleep(time.Second * 1)
-		payload, err := json.Marshal(time.Now())
+		err := times.Send(guest.Conn().ServerTime())
 		if err != nil {
-			panic("failed to marshal time")
+			panic("failed to send time " + err.Error())
 		}
-		guest.Conn().Write(payload)
 	}
 	<-exit
 }
@@ -54,20 +52,15 @@ func main() {
 var exit = make(chan struct{})
 
 func OnConnect(conn client.Conn) {
-	var buf [1500]byte
 	fmt.Println("new connection!")
+	times := client.NewTypedConn[time.Time](conn, client.JSON)
 	for {
-		n, err := conn.Read(buf[:])
+		t, err := times.Recv()
 		if err != nil {
 			fmt.Println(err)
 			close(exit)
 			return
 		}
-		t := time.Time{}
-		err = json.Unmarshal(buf[:n], &t)
-		if err != nil {
-			panic("failed to unmarshal time " + err.Error())
-		}
-		fmt.Println(time.Since(t))
+		fmt.Println(conn.ServerTime().Sub(t))
 	}
 }
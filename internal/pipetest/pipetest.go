@@ -0,0 +1,106 @@
+// Package pipetest connects the packages built on top of client in tests,
+// without going through ice.
+package pipetest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/BrownNPC/Ice-Data-Channel/client"
+)
+
+// End is one end of an in memory message connection.
+// It has the Write and ReadMessage methods of a client.Channel.
+type End struct {
+	in, out chan []byte
+	closed  chan struct{}
+	once    *sync.Once
+
+	mu sync.Mutex
+	// every loss-th message written is lost, 0 loses none
+	loss  int
+	sent  int
+	bytes int
+	// largest message that can be written, 0 is unlimited
+	limit int
+}
+
+// Pipe returns both ends of a connection, each buffering size messages.
+func Pipe(size int) (*End, *End) {
+	a, b := make(chan []byte, size), make(chan []byte, size)
+	closed, once := make(chan struct{}), &sync.Once{}
+	return &End{in: a, out: b, closed: closed, once: once}, &End{in: b, out: a, closed: closed, once: once}
+}
+
+// Lossy loses every loss-th message written to p from now on.
+// lossy ends also drop messages when the other end falls behind, like datagrams
+func (p *End) Lossy(loss int) *End {
+	p.mu.Lock()
+	p.loss = loss
+	p.mu.Unlock()
+	return p
+}
+
+// Limit fails writes of messages larger than n with client.ErrMessageTooLarge,
+// like a channel with a Config.MaxMessageSize of n.
+func (p *End) Limit(n int) *End {
+	p.mu.Lock()
+	p.limit = n
+	p.mu.Unlock()
+	return p
+}
+
+func (p *End) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if p.limit > 0 && len(b) > p.limit {
+		p.mu.Unlock()
+		return 0, client.ErrMessageTooLarge
+	}
+	p.sent++
+	p.bytes += len(b)
+	lossy := p.loss > 0
+	lost := lossy && p.sent%p.loss == 0
+	p.mu.Unlock()
+	if lost {
+		return len(b), nil
+	}
+	msg := append([]byte(nil), b...)
+	if lossy {
+		select {
+		case p.out <- msg:
+		default: // full, dropped like a datagram
+		}
+		return len(b), nil
+	}
+	select {
+	case p.out <- msg:
+		return len(b), nil
+	case <-p.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (p *End) ReadMessage() ([]byte, error) {
+	select {
+	case b := <-p.in:
+		return b, nil
+	case <-p.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close both ends
+func (p *End) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+// Buffered is how many messages wait to be read from p
+func (p *End) Buffered() int { return len(p.in) }
+
+// Bytes written to this end so far, including lost messages
+func (p *End) Bytes() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bytes
+}
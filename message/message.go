@@ -19,9 +19,22 @@ type Msg struct {
 	RoomID string
 	// Id of the connection this message is related to in some way
 	From, To uuid.UUID //role depends on message type
+	// Id of the guest's session, it stays the same when the guest reconnects
+	Session uuid.UUID
 
 	// ICE
 	Ufrag, Pwd, Candidate string
+	// message compression the guest offers, or the owner agrees to
+	Compression string
+	// what the guest tells the owner about itself
+	Metadata map[string]string
+	// the guest is resuming Session, instead of joining for the first time
+	Resume bool
+	// only the guest that created a session knows it,
+	// the owner checks it before resuming the session
+	Secret string
+	// why the owner kicked the guest
+	KickReason string
 }
 
 func Decode(b []byte) (msg Msg) {
@@ -19,11 +19,17 @@ func _() {
 	_ = x[IceAuthResponse-8]
 	_ = x[IceCandidatesEnd-9]
 	_ = x[GuestDisconnected-10]
+	_ = x[Kick-11]
+	_ = x[IceRestart-12]
+	_ = x[IceRestartResponse-13]
+	_ = x[Leave-14]
+	_ = x[RoomClosed-15]
+	_ = x[ResumeRejected-16]
 }
 
-const _Type_name = "InvalidPingCreateRoomRequestCreateRoomResponseJoinRoomRequestIceCandidateForOwnerIceCandidateForGuestIceAuthInitiateIceAuthResponseIceCandidatesEndGuestDisconnected"
+const _Type_name = "InvalidPingCreateRoomRequestCreateRoomResponseJoinRoomRequestIceCandidateForOwnerIceCandidateForGuestIceAuthInitiateIceAuthResponseIceCandidatesEndGuestDisconnectedKickIceRestartIceRestartResponseLeaveRoomClosedResumeRejected"
 
-var _Type_index = [...]uint8{0, 7, 11, 28, 46, 61, 81, 101, 116, 131, 147, 164}
+var _Type_index = [...]uint8{0, 7, 11, 28, 46, 61, 81, 101, 116, 131, 147, 164, 168, 178, 196, 201, 211, 225}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
@@ -20,6 +20,14 @@ const (
 
 	GuestDisconnected
 	Kick
+
+	IceRestart
+	IceRestartResponse
+
+	Leave
+	RoomClosed
+
+	ResumeRejected
 )
 
 // connection creates a room
@@ -53,20 +61,28 @@ func JoinRoomRequestMsg(RoomID string) Msg {
 	}
 }
 
-// the guest initiates the ice auth
-func IceAuthInitiateMsg(ufrag, pwd string) Msg {
+// the guest initiates the ice auth.
+// resume is set when the guest lost its connection and wants its session back,
+// secret proves it is the guest that created the session
+func IceAuthInitiateMsg(ufrag, pwd string, Session uuid.UUID, resume bool, secret string, compression string, metadata map[string]string) Msg {
 	return Msg{
-		Type:  IceAuthInitiate,
-		Ufrag: ufrag, Pwd: pwd,
+		Type:    IceAuthInitiate,
+		Session: Session,
+		Resume:  resume,
+		Secret:  secret,
+		Ufrag:   ufrag, Pwd: pwd,
+		Compression: compression,
+		Metadata:    metadata,
 	}
 }
 
 // owner responds with its own credentials
-func IceAuthResponseMsg(ufrag, pwd string, To uuid.UUID) Msg {
+func IceAuthResponseMsg(ufrag, pwd string, To uuid.UUID, compression string) Msg {
 	return Msg{
 		Type:  IceAuthResponse,
 		To:    To,
 		Ufrag: ufrag, Pwd: pwd,
+		Compression: compression,
 	}
 }
 func IceCandidateForOwnerMsg(candidate string) Msg {
@@ -83,11 +99,41 @@ func IceCandidateForGuestMsg(candidate string, To uuid.UUID) Msg {
 	}
 }
 
-// the owner tells the signaling server to kick this peer
-func KickMsg(Target uuid.UUID) Msg {
+// the owner tells the signaling server to kick this peer,
+// the server forwards it to the peer. reason can be empty
+func KickMsg(Target uuid.UUID, reason string) Msg {
+	return Msg{
+		To:         Target,
+		Type:       Kick,
+		KickReason: reason,
+	}
+}
+
+// the guest restarts ice with fresh credentials
+// after the connection was lost
+func IceRestartMsg(ufrag, pwd string) Msg {
+	return Msg{
+		Type:  IceRestart,
+		Ufrag: ufrag, Pwd: pwd,
+	}
+}
+
+// owner restarts its agent and responds with its fresh credentials
+func IceRestartResponseMsg(ufrag, pwd string, To uuid.UUID) Msg {
+	return Msg{
+		Type:    IceRestartResponse,
+		To:      To,
+		Success: true,
+		Ufrag:   ufrag, Pwd: pwd,
+	}
+}
+
+// owner could not restart ice for the guest
+func IceRestartFailedMsg(To uuid.UUID, cause string) Msg {
 	return Msg{
-		To:   Target,
-		Type: Kick,
+		Type:  IceRestartResponse,
+		To:    To,
+		Cause: cause,
 	}
 }
 func PingMsg() Msg {
@@ -95,3 +141,25 @@ func PingMsg() Msg {
 		Type: Ping,
 	}
 }
+
+// the guest leaves the room, the server tells the owner
+func LeaveMsg() Msg {
+	return Msg{
+		Type: Leave,
+	}
+}
+
+// the owner no longer has the session the guest wants to resume
+func ResumeRejectedMsg(To uuid.UUID) Msg {
+	return Msg{
+		Type: ResumeRejected,
+		To:   To,
+	}
+}
+
+// the owner closes the room, the server tells every guest
+func RoomClosedMsg() Msg {
+	return Msg{
+		Type: RoomClosed,
+	}
+}
@@ -0,0 +1,476 @@
+// Package netcode keeps the simulation of a peer hosted game in sync
+// with rollback, on top of [client.Conn].
+//
+// Every peer simulates the game itself from the inputs of all players.
+// Inputs are exchanged per frame, and frames are simulated right away with
+// predicted inputs for players whose input did not arrive yet. When the
+// real input turns out to be different, the game is rolled back to the
+// frame it was mispredicted on and simulated again.
+//
+// Peers exchange checksums of the frames everyone agrees on,
+// to detect when simulations desync.
+//
+// In a star, where guests only connect to the owner, the owner relays
+// inputs with [Config].Relay. In a mesh every peer adds all the others.
+package netcode
+
+import (
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+
+	"github.com/BrownNPC/Ice-Data-Channel/client"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Channel of a [client.Conn] that AddPeer exchanges inputs over
+const Channel uint8 = 252
+
+// Frame number, the first frame is 0
+type Frame int32
+
+const (
+	// frames of inputs kept for peers that are behind
+	inputHistory = 256
+	// most frames of one player's inputs in one message
+	maxInputsPerMessage = 32
+	// checksums of recent frames sent with every message
+	checksumsPerMessage = 4
+)
+
+// AddLocalInput was already called for this frame
+var ErrInputAdded = errors.New("local input already added for this frame")
+
+// Game is simulated by a [Session]. It must be deterministic,
+// the same inputs have to lead to the same state on every peer.
+type Game interface {
+	// Save the state of the game
+	Save() []byte
+	// Load a state returned by Save
+	Load(state []byte)
+	// Advance the game by one frame, with one input for every player.
+	// An input is nil if the player has not sent one yet.
+	Advance(inputs [][]byte)
+}
+
+type Config struct {
+	// number of players, and which of them is local
+	Players     int
+	LocalPlayer int
+	// frames between adding an input and it being simulated.
+	// a higher delay means fewer rollbacks
+	InputDelay int
+	// frames the game may run ahead of the inputs of the slowest player
+	MaxPrediction int
+	// forward inputs between peers, for the owner in a star topology
+	Relay bool
+	// exchange a checksum every this many frames, 0 means every 30
+	ChecksumInterval int
+}
+
+// Session runs a [Game] in sync with the other peers.
+// It is safe to use from multiple goroutines.
+type Session struct {
+	game Game
+	cfg  Config
+
+	mu sync.Mutex
+	// next frame to simulate
+	frame Frame
+	// inputs of every player by frame
+	inputs [][]inputSlot
+	// highest frame up to which the inputs of every player arrived
+	confirmed []Frame
+	// earliest frame simulated with a wrong prediction, -1 if none
+	rollback Frame
+	// state before every frame that may be rolled back to,
+	// and the inputs it was advanced with
+	frames map[Frame]*frameRecord
+	// checksums of agreed on frames
+	checksums    map[Frame]uint64
+	lastChecksum Frame
+	peers        []*peer
+
+	onDesync func(frame Frame, local, remote uint64)
+	// desyncs found while s.mu was held, reported after unlocking
+	desyncs []desync
+}
+
+type desync struct {
+	frame         Frame
+	local, remote uint64
+}
+
+type inputSlot struct {
+	frame Frame
+	input []byte
+	set   bool
+}
+
+type frameRecord struct {
+	state  []byte
+	inputs [][]byte
+}
+
+type peer struct {
+	conn client.MessageConn
+	// highest frame of every player the peer has all inputs up to
+	acks []Frame
+	// players whose inputs come from this peer
+	origin []bool
+	// checksums the peer sent that weren't compared yet,
+	// and the last frame that was
+	checksums map[Frame]uint64
+	compared  Frame
+}
+
+// one message between peers
+type message struct {
+	_msgpack struct{} `msgpack:",omitempty"`
+
+	Inputs    []playerInputs
+	Confirmed []Frame
+	Checksums []frameChecksum
+}
+
+type playerInputs struct {
+	Player int
+	Start  Frame
+	Inputs [][]byte
+}
+
+type frameChecksum struct {
+	Frame Frame
+	Sum   uint64
+}
+
+// New session simulating game. The frames before InputDelay have no inputs.
+func New(game Game, cfg Config) *Session {
+	if cfg.ChecksumInterval <= 0 {
+		cfg.ChecksumInterval = 30
+	}
+	cfg.InputDelay = max(cfg.InputDelay, 0)
+	cfg.MaxPrediction = max(cfg.MaxPrediction, 0)
+	s := &Session{
+		game:         game,
+		cfg:          cfg,
+		inputs:       make([][]inputSlot, cfg.Players),
+		confirmed:    make([]Frame, cfg.Players),
+		rollback:     -1,
+		frames:       map[Frame]*frameRecord{},
+		checksums:    map[Frame]uint64{},
+		lastChecksum: -1,
+	}
+	for p := range cfg.Players {
+		s.inputs[p] = make([]inputSlot, inputHistory)
+		s.confirmed[p] = Frame(cfg.InputDelay) - 1
+	}
+	return s
+}
+
+// OnDesync calls f when a peer reports a different checksum for a frame
+// than the local simulation. Once simulations desynced they stay that way,
+// the game should be ended or resynced from one peer's state.
+func (s *Session) OnDesync(f func(frame Frame, local, remote uint64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onDesync = f
+}
+
+// AddPeer exchanges inputs with the remote peer of conn, over channel [Channel].
+func (s *Session) AddPeer(conn client.Conn) {
+	s.AddPeerOver(conn.Channel(Channel, client.UnreliableSequenced))
+}
+
+// AddPeerOver exchanges inputs over conn.
+// Messages may be lost, they are sent again until the peer has them.
+func (s *Session) AddPeerOver(conn client.MessageConn) {
+	p := &peer{
+		conn:      conn,
+		acks:      make([]Frame, s.cfg.Players),
+		origin:    make([]bool, s.cfg.Players),
+		checksums: map[Frame]uint64{},
+		compared:  -1,
+	}
+	for i := range p.acks {
+		p.acks[i] = Frame(s.cfg.InputDelay) - 1
+	}
+	s.mu.Lock()
+	s.peers = append(s.peers, p)
+	s.mu.Unlock()
+	go s.readLoop(p)
+}
+
+// Frame that the next call of Advance simulates
+func (s *Session) Frame() Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frame
+}
+
+// ConfirmedFrame is the last frame the inputs of every player arrived for.
+// It won't be rolled back anymore.
+func (s *Session) ConfirmedFrame() Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.minConfirmed()
+}
+
+// AddLocalInput sets the input of the local player for the frame InputDelay frames from now.
+// It should be called once before every Advance, frames it was not called for
+// repeat the previous input.
+func (s *Session) AddLocalInput(input []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.cfg.LocalPlayer
+	target := s.frame + Frame(s.cfg.InputDelay)
+	if s.confirmed[p] >= target {
+		return ErrInputAdded
+	}
+	for s.confirmed[p] < target-1 {
+		s.setInput(p, s.confirmed[p]+1, s.input(p, s.confirmed[p]))
+	}
+	s.setInput(p, target, input)
+	return nil
+}
+
+// Advance rolls back frames that were predicted wrong, and simulates the next frame.
+// It returns false without simulating when the game is MaxPrediction
+// frames ahead of the slowest player, the caller should try again next tick.
+func (s *Session) Advance() bool {
+	s.mu.Lock()
+	defer s.reportDesyncs()
+	if s.rollback >= 0 {
+		s.rollBack()
+	}
+	// the local player is never predicted
+	local := s.cfg.LocalPlayer
+	for s.confirmed[local] < s.frame {
+		s.setInput(local, s.confirmed[local]+1, s.input(local, s.confirmed[local]))
+	}
+	if s.frame-s.minConfirmed() > Frame(s.cfg.MaxPrediction) {
+		s.sendAll()
+		return false
+	}
+	s.simulate(s.frame)
+	s.frame++
+	s.checksum()
+	s.prune()
+	s.sendAll()
+	return true
+}
+
+// simulate one frame, saving the state before it. must be called with s.mu held
+func (s *Session) simulate(frame Frame) {
+	inputs := make([][]byte, s.cfg.Players)
+	for p := range inputs {
+		inputs[p] = s.input(p, frame)
+	}
+	s.frames[frame] = &frameRecord{state: s.game.Save(), inputs: inputs}
+	s.game.Advance(inputs)
+}
+
+// load the state before the first mispredicted frame, and simulate up to the current frame again
+func (s *Session) rollBack() {
+	record, ok := s.frames[s.rollback]
+	if !ok {
+		slog.Error("can't roll back, state is gone", "frame", s.rollback)
+		s.rollback = -1
+		return
+	}
+	s.game.Load(record.state)
+	for frame := s.rollback; frame < s.frame; frame++ {
+		s.simulate(frame)
+	}
+	s.rollback = -1
+}
+
+// input of player for frame, the last known one if it did not arrive yet
+func (s *Session) input(player int, frame Frame) []byte {
+	// the last known input is the prediction
+	frame = min(frame, s.confirmed[player])
+	if frame < Frame(s.cfg.InputDelay) {
+		return nil
+	}
+	if slot := s.inputs[player][frame%inputHistory]; slot.set && slot.frame == frame {
+		return slot.input
+	}
+	return nil
+}
+
+// remember an input, and roll back if a prediction for it was wrong.
+// inputs must be added in order. must be called with s.mu held
+func (s *Session) setInput(player int, frame Frame, input []byte) {
+	if frame != s.confirmed[player]+1 {
+		return
+	}
+	if record, ok := s.frames[frame]; ok && frame < s.frame && !equal(record.inputs[player], input) {
+		if s.rollback < 0 || frame < s.rollback {
+			s.rollback = frame
+		}
+	}
+	s.inputs[player][frame%inputHistory] = inputSlot{frame: frame, input: input, set: true}
+	s.confirmed[player] = frame
+}
+
+func (s *Session) minConfirmed() Frame {
+	confirmed := s.frame
+	for _, c := range s.confirmed {
+		confirmed = min(confirmed, c)
+	}
+	return confirmed
+}
+
+// checksum the states no rollback can change anymore
+func (s *Session) checksum() {
+	if s.rollback >= 0 {
+		return
+	}
+	interval := Frame(s.cfg.ChecksumInterval)
+	// the state before a frame only depends on the inputs of earlier frames
+	agreed := min(s.minConfirmed()+1, s.frame-1)
+	for frame := (s.lastChecksum/interval + 1) * interval; frame <= agreed; frame += interval {
+		record, ok := s.frames[frame]
+		if !ok {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write(record.state)
+		s.checksums[frame] = h.Sum64()
+		s.lastChecksum = frame
+		for _, p := range s.peers {
+			s.compare(p, frame)
+		}
+	}
+}
+
+// report a desync if a peer simulated frame differently
+func (s *Session) compare(p *peer, frame Frame) {
+	local, ok := s.checksums[frame]
+	remote, remoteOk := p.checksums[frame]
+	if !ok || !remoteOk {
+		return
+	}
+	delete(p.checksums, frame)
+	p.compared = max(p.compared, frame)
+	if local != remote {
+		s.desyncs = append(s.desyncs, desync{frame, local, remote})
+	}
+}
+
+// unlock s.mu, and call OnDesync for the desyncs found while it was held
+func (s *Session) reportDesyncs() {
+	desyncs, f := s.desyncs, s.onDesync
+	s.desyncs = nil
+	s.mu.Unlock()
+	if f == nil {
+		return
+	}
+	for _, d := range desyncs {
+		f(d.frame, d.local, d.remote)
+	}
+}
+
+// forget states that can't be rolled back to anymore
+func (s *Session) prune() {
+	// only frames after the confirmed one can be rolled back to,
+	// the confirmed ones were checksummed already
+	confirmed := s.minConfirmed()
+	for frame := range s.frames {
+		if frame <= confirmed {
+			delete(s.frames, frame)
+		}
+	}
+	for frame := range s.checksums {
+		if frame < s.lastChecksum-Frame(s.cfg.ChecksumInterval*checksumsPerMessage) {
+			delete(s.checksums, frame)
+		}
+	}
+	for _, p := range s.peers {
+		for frame := range p.checksums {
+			if frame < s.frame-inputHistory {
+				delete(p.checksums, frame)
+			}
+		}
+	}
+}
+
+// send every peer the inputs it is missing. must be called with s.mu held
+func (s *Session) sendAll() {
+	for _, p := range s.peers {
+		msg := message{Confirmed: s.confirmed}
+		for player := range s.cfg.Players {
+			// peers get the local player's inputs, and when relaying those of everyone else
+			if player != s.cfg.LocalPlayer && (!s.cfg.Relay || p.origin[player]) {
+				continue
+			}
+			start := max(p.acks[player]+1, s.confirmed[player]-inputHistory+1)
+			end := min(s.confirmed[player], start+maxInputsPerMessage-1)
+			if start > end {
+				continue
+			}
+			in := playerInputs{Player: player, Start: start}
+			for frame := start; frame <= end; frame++ {
+				in.Inputs = append(in.Inputs, s.input(player, frame))
+			}
+			msg.Inputs = append(msg.Inputs, in)
+		}
+		for frame := s.lastChecksum; frame >= 0 && len(msg.Checksums) < checksumsPerMessage; frame -= Frame(s.cfg.ChecksumInterval) {
+			if sum, ok := s.checksums[frame]; ok {
+				msg.Checksums = append(msg.Checksums, frameChecksum{Frame: frame, Sum: sum})
+			}
+		}
+		b, err := msgpack.Marshal(&msg)
+		if err != nil {
+			slog.Error("failed to encode inputs", "error", err)
+			return
+		}
+		p.conn.Write(b)
+	}
+}
+
+func (s *Session) readLoop(p *peer) {
+	for {
+		b, err := p.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg message
+		if msgpack.Unmarshal(b, &msg) != nil {
+			continue
+		}
+		s.receive(p, msg)
+	}
+}
+
+func (s *Session) receive(p *peer, msg message) {
+	s.mu.Lock()
+	defer s.reportDesyncs()
+	for player, confirmed := range msg.Confirmed {
+		if player < len(p.acks) {
+			p.acks[player] = max(p.acks[player], confirmed)
+		}
+	}
+	for _, in := range msg.Inputs {
+		if in.Player < 0 || in.Player >= s.cfg.Players || in.Player == s.cfg.LocalPlayer {
+			continue
+		}
+		p.origin[in.Player] = true
+		for i, input := range in.Inputs {
+			s.setInput(in.Player, in.Start+Frame(i), input)
+		}
+	}
+	for _, c := range msg.Checksums {
+		// checksums are sent more than once
+		if c.Frame <= p.compared {
+			continue
+		}
+		p.checksums[c.Frame] = c.Sum
+		s.compare(p, c.Frame)
+	}
+}
+
+func equal(a, b []byte) bool {
+	return string(a) == string(b)
+}
@@ -0,0 +1,165 @@
+package netcode
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/BrownNPC/Ice-Data-Channel/internal/pipetest"
+)
+
+// connection between two sessions that loses every third message
+func pipe() (*pipetest.End, *pipetest.End) {
+	a, b := pipetest.Pipe(1024)
+	return a.Lossy(3), b.Lossy(3)
+}
+
+// counts frames and hashes the inputs of every frame into its value
+type game struct {
+	frame   uint32
+	value   uint64
+	history map[uint32]uint64
+	// added to the value on this frame, to desync
+	cheat uint32
+}
+
+func newGame() *game { return &game{history: map[uint32]uint64{}} }
+
+func (g *game) Save() []byte {
+	b := binary.BigEndian.AppendUint32(nil, g.frame)
+	return binary.BigEndian.AppendUint64(b, g.value)
+}
+
+func (g *game) Load(state []byte) {
+	g.frame = binary.BigEndian.Uint32(state)
+	g.value = binary.BigEndian.Uint64(state[4:])
+}
+
+func (g *game) Advance(inputs [][]byte) {
+	for _, in := range inputs {
+		g.value = g.value*31 + 1
+		for _, b := range in {
+			g.value = g.value*31 + uint64(b)
+		}
+	}
+	if g.cheat != 0 && g.frame == g.cheat {
+		g.value++
+	}
+	g.history[g.frame] = g.value
+	g.frame++
+}
+
+// run every session until frames are confirmed everywhere,
+// with a different input from every player every frame
+func run(t *testing.T, sessions []*Session, frames Frame) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		done := true
+		for i, s := range sessions {
+			s.AddLocalInput([]byte{byte(i), byte(s.Frame())})
+			s.Advance()
+			done = done && s.ConfirmedFrame() >= frames
+		}
+		if done {
+			// roll back frames whose inputs arrived since the last advance
+			for _, s := range sessions {
+				s.Advance()
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sessions did not confirm every frame")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMesh(t *testing.T) {
+	const players = 3
+	var games [players]*game
+	var sessions []*Session
+	for i := range players {
+		games[i] = newGame()
+		sessions = append(sessions, New(games[i], Config{Players: players, LocalPlayer: i, InputDelay: 2, MaxPrediction: 8, ChecksumInterval: 10}))
+		sessions[i].OnDesync(func(frame Frame, local, remote uint64) {
+			t.Errorf("player %d desynced on frame %d", i, frame)
+		})
+	}
+	for i := range players {
+		for j := i + 1; j < players; j++ {
+			a, b := pipe()
+			sessions[i].AddPeerOver(a)
+			sessions[j].AddPeerOver(b)
+		}
+	}
+	run(t, sessions, 200)
+	for i := 1; i < players; i++ {
+		for frame := range uint32(200) {
+			if games[i].history[frame] != games[0].history[frame] {
+				t.Fatalf("player %d simulated frame %d differently", i, frame)
+			}
+		}
+	}
+}
+
+func TestStar(t *testing.T) {
+	const players = 3
+	var games [players]*game
+	var sessions []*Session
+	for i := range players {
+		games[i] = newGame()
+		// player 0 owns the room and relays
+		sessions = append(sessions, New(games[i], Config{Players: players, LocalPlayer: i, InputDelay: 1, MaxPrediction: 8, Relay: i == 0}))
+	}
+	for i := 1; i < players; i++ {
+		a, b := pipe()
+		sessions[0].AddPeerOver(a)
+		sessions[i].AddPeerOver(b)
+	}
+	run(t, sessions, 200)
+	for i := 1; i < players; i++ {
+		for frame := range uint32(200) {
+			if games[i].history[frame] != games[0].history[frame] {
+				t.Fatalf("player %d simulated frame %d differently", i, frame)
+			}
+		}
+	}
+}
+
+func TestDesync(t *testing.T) {
+	a, b := newGame(), newGame()
+	b.cheat = 25
+	sa := New(a, Config{Players: 2, LocalPlayer: 0, MaxPrediction: 4, ChecksumInterval: 10})
+	sb := New(b, Config{Players: 2, LocalPlayer: 1, MaxPrediction: 4, ChecksumInterval: 10})
+	desynced := make(chan Frame, 16)
+	sa.OnDesync(func(frame Frame, local, remote uint64) { desynced <- frame })
+	pa, pb := pipe()
+	sa.AddPeerOver(pa)
+	sb.AddPeerOver(pb)
+	run(t, []*Session{sa, sb}, 100)
+	select {
+	case frame := <-desynced:
+		if frame != 30 {
+			t.Errorf("desync detected on frame %d, want 30", frame)
+		}
+	case <-time.After(time.Second):
+		t.Error("desync not detected")
+	}
+}
+
+func TestLocalInputOncePerFrame(t *testing.T) {
+	s := New(newGame(), Config{Players: 1})
+	if err := s.AddLocalInput([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddLocalInput([]byte{2}); err != ErrInputAdded {
+		t.Errorf("second input for a frame returned %v", err)
+	}
+	if !s.Advance() {
+		t.Fatal("a single player is never waited on")
+	}
+	if err := s.AddLocalInput([]byte{2}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Call method on the remote and wait for its response.
+// The call is canceled on the remote when ctx is done,
+// a deadline of ctx also limits how long the remote handles it.
+// Calling a streaming method returns its first response.
+func Call[Resp any](ctx context.Context, p *Peer, method string, req any) (resp Resp, err error) {
+	c, err := p.start(ctx, method, req)
+	if err != nil {
+		return
+	}
+	f, err := p.next(ctx, c)
+	if err != nil {
+		return
+	}
+	switch f.Kind {
+	case frameItem:
+		p.abort(c)
+	case frameReply:
+		p.finish(c)
+	case frameError:
+		p.finish(c)
+		err = &Error{Method: method, Message: f.Error, code: f.Code}
+		return
+	}
+	// methods without a result reply without a body
+	if len(f.Body) > 0 {
+		err = msgpack.Unmarshal(f.Body, &resp)
+	}
+	return
+}
+
+// Stream of responses of a call.
+type Stream[Resp any] struct {
+	p      *Peer
+	ctx    context.Context
+	call   *call
+	method string
+	done   bool
+	err    error
+}
+
+// CallStream calls a method that responds with a stream of values, see [HandleStream].
+// The call is canceled on the remote when ctx is done.
+// Recv must be called until it returns an error, or the stream closed,
+// unread responses hold back the other calls on the connection.
+func CallStream[Resp any](ctx context.Context, p *Peer, method string, req any) (*Stream[Resp], error) {
+	c, err := p.start(ctx, method, req)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream[Resp]{p: p, ctx: ctx, call: c, method: method}, nil
+}
+
+// Recv the next response. It returns [io.EOF] once the stream ended.
+func (s *Stream[Resp]) Recv() (resp Resp, err error) {
+	if s.done {
+		return resp, s.err
+	}
+	f, err := s.p.next(s.ctx, s.call)
+	if err != nil {
+		s.done, s.err = true, err
+		return
+	}
+	switch f.Kind {
+	case frameItem:
+		err = msgpack.Unmarshal(f.Body, &resp)
+		return
+	case frameError:
+		s.p.finish(s.call)
+		s.done, s.err = true, &Error{Method: s.method, Message: f.Error, code: f.Code}
+		return resp, s.err
+	}
+	// frameReply, a plain method responds once
+	s.p.finish(s.call)
+	s.done, s.err = true, io.EOF
+	if len(f.Body) > 0 {
+		err = msgpack.Unmarshal(f.Body, &resp)
+		return
+	}
+	return resp, io.EOF
+}
+
+// Close the stream, canceling the call if it is still running.
+func (s *Stream[Resp]) Close() error {
+	if !s.done {
+		s.done, s.err = true, io.EOF
+		s.p.abort(s.call)
+	}
+	return nil
+}
+
+// Group of peers to call at once, like all guests of an owner.
+// Peers leave the group when they are closed.
+type Group struct {
+	mu    sync.Mutex
+	peers map[*Peer]struct{}
+}
+
+func NewGroup() *Group {
+	return &Group{peers: map[*Peer]struct{}{}}
+}
+
+// Add p to the group, until it is closed.
+func (g *Group) Add(p *Peer) {
+	g.mu.Lock()
+	g.peers[p] = struct{}{}
+	g.mu.Unlock()
+	go func() {
+		<-p.Done()
+		g.Remove(p)
+	}()
+}
+
+func (g *Group) Remove(p *Peer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.peers, p)
+}
+
+// Peers in the group
+func (g *Group) Peers() []*Peer {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	peers := make([]*Peer, 0, len(g.peers))
+	for p := range g.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Result of calling one peer of a [Group].
+type Result[Resp any] struct {
+	Peer *Peer
+	Resp Resp
+	Err  error
+}
+
+// Broadcast calls method on every peer of the group at once,
+// and waits for all of them to respond or fail.
+func Broadcast[Resp any](ctx context.Context, g *Group, method string, req any) []Result[Resp] {
+	peers := g.Peers()
+	results := make([]Result[Resp], len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := Call[Resp](ctx, p, method, req)
+			results[i] = Result[Resp]{Peer: p, Resp: resp, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
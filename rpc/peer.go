@@ -0,0 +1,273 @@
+// Package rpc calls methods of the remote peer over a reliable channel of a [client.Conn].
+//
+// Both sides of a connection create a [Peer] with the methods they serve,
+// and can then call the methods of the other side:
+//
+//	server := rpc.NewServer()
+//	rpc.Handle(server, "spawn", func(ctx context.Context, req SpawnRequest) (Player, error) { ... })
+//	peer := rpc.NewPeer(conn, server)
+//	player, err := rpc.Call[Player](ctx, peer, "spawn", SpawnRequest{...})
+//
+// Owners can call all of their guests at once with a [Group].
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/BrownNPC/Ice-Data-Channel/client"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Channel of a [client.Conn] that NewPeer sends calls over
+const Channel uint8 = 255
+
+// kinds of frames
+const (
+	frameCall   uint8 = iota + 1 // start a call
+	frameItem                    // one response of a streaming call
+	frameReply                   // the call finished, with its response if it has one
+	frameError                   // the call failed
+	frameCancel                  // the caller gave up
+)
+
+// one message on the channel.
+// ids are picked by the caller, calls in each direction have their own ids.
+type frame struct {
+	_msgpack struct{} `msgpack:",omitempty"`
+
+	Kind   uint8
+	ID     uint64
+	Method string
+	// arguments or response, encoded with msgpack
+	Body []byte
+	// how long the caller waits for the call, 0 for no limit
+	Timeout time.Duration
+	// why the call failed
+	Error string
+	Code  uint8
+}
+
+// error codes
+const (
+	codeHandler uint8 = iota
+	codeUnknownMethod
+	codeDeadlineExceeded
+)
+
+var (
+	// the remote does not serve the method
+	ErrUnknownMethod = errors.New("unknown method")
+	// the peer was closed, or its connection was
+	ErrClosed = errors.New("rpc peer closed")
+)
+
+// Error is returned by calls that failed on the remote.
+type Error struct {
+	Method  string
+	Message string
+	code    uint8
+}
+
+func (err *Error) Error() string {
+	return "rpc " + err.Method + ": " + err.Message
+}
+
+func (err *Error) Is(target error) bool {
+	switch err.code {
+	case codeUnknownMethod:
+		return target == ErrUnknownMethod
+	case codeDeadlineExceeded:
+		return target == context.DeadlineExceeded
+	}
+	return false
+}
+
+// Peer is one side of an rpc connection. It serves calls from the remote,
+// and makes calls to it. It is safe to use from multiple goroutines.
+type Peer struct {
+	conn   client.MessageConn
+	server *Server
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	nextID uint64
+	// calls waiting for the remote
+	calls map[uint64]*call
+	// calls of the remote being handled
+	handling map[uint64]context.CancelFunc
+	err      error
+}
+
+// NewPeer serves calls on conn with server, which may be nil to only make calls.
+// The calls go over the reliable channel [Channel] of conn.
+func NewPeer(conn client.Conn, server *Server) *Peer {
+	return Over(conn.Channel(Channel, client.Reliable), server)
+}
+
+// Over serves calls on conn, which must deliver messages reliably and in order,
+// like a [client.Reliable] channel.
+func Over(conn client.MessageConn, server *Server) *Peer {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Peer{
+		conn:     conn,
+		server:   server,
+		ctx:      ctx,
+		cancel:   cancel,
+		calls:    map[uint64]*call{},
+		handling: map[uint64]context.CancelFunc{},
+	}
+	go p.readLoop()
+	return p
+}
+
+// Done is closed when the peer stops, because it or its connection was closed.
+func (p *Peer) Done() <-chan struct{} { return p.ctx.Done() }
+
+// Close stops serving calls, and fails the calls waiting for the remote.
+// The channel the peer runs on is closed too.
+func (p *Peer) Close() error {
+	p.close(ErrClosed)
+	if closer, ok := p.conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (p *Peer) close(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	p.err = err
+	p.cancel()
+}
+
+func (p *Peer) readLoop() {
+	for {
+		msg, err := p.conn.ReadMessage()
+		if err != nil {
+			p.close(errors.Join(ErrClosed, err))
+			return
+		}
+		select {
+		case <-p.ctx.Done():
+			return
+		default:
+		}
+		var f frame
+		if msgpack.Unmarshal(msg, &f) != nil {
+			continue
+		}
+		switch f.Kind {
+		case frameCall:
+			p.serve(f)
+		case frameCancel:
+			p.mu.Lock()
+			if cancel, ok := p.handling[f.ID]; ok {
+				cancel()
+			}
+			p.mu.Unlock()
+		case frameItem, frameReply, frameError:
+			p.mu.Lock()
+			c, ok := p.calls[f.ID]
+			p.mu.Unlock()
+			if !ok {
+				continue // the caller gave up
+			}
+			// a slow stream reader holds back the connection,
+			// until it gives up
+			select {
+			case c.frames <- f:
+			case <-c.done:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (p *Peer) send(f frame) error {
+	msg, err := msgpack.Marshal(&f)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.Write(msg)
+	return err
+}
+
+// a call waiting for the remote
+type call struct {
+	id     uint64
+	frames chan frame
+	// closed when the caller stops waiting
+	done chan struct{}
+}
+
+// start a call, the frames answering it arrive on frames
+func (p *Peer) start(ctx context.Context, method string, req any) (*call, error) {
+	body, err := msgpack.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return nil, p.err
+	}
+	c := &call{id: p.nextID, frames: make(chan frame, 16), done: make(chan struct{})}
+	p.nextID++
+	p.calls[c.id] = c
+	p.mu.Unlock()
+
+	f := frame{Kind: frameCall, ID: c.id, Method: method, Body: body}
+	if deadline, ok := ctx.Deadline(); ok {
+		f.Timeout = max(time.Until(deadline), 1)
+	}
+	if err = p.send(f); err != nil {
+		p.finish(c)
+		return nil, err
+	}
+	return c, nil
+}
+
+// wait for the next frame of a call
+func (p *Peer) next(ctx context.Context, c *call) (frame, error) {
+	select {
+	case f := <-c.frames:
+		return f, nil
+	case <-ctx.Done():
+		p.abort(c)
+		return frame{}, ctx.Err()
+	case <-p.ctx.Done():
+		p.finish(c)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return frame{}, p.err
+	}
+}
+
+// stop waiting for a call, and tell the remote to stop handling it
+func (p *Peer) abort(c *call) {
+	if p.finish(c) {
+		p.send(frame{Kind: frameCancel, ID: c.id})
+	}
+}
+
+// forget a call, returns false if it already finished
+func (p *Peer) finish(c *call) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.calls[c.id]; !ok {
+		return false
+	}
+	delete(p.calls, c.id)
+	close(c.done)
+	return true
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/BrownNPC/Ice-Data-Channel/internal/pipetest"
+)
+
+type move struct{ X, Y int }
+
+func TestCall(t *testing.T) {
+	a, b := pipetest.Pipe(64)
+	server := NewServer()
+	Handle(server, "validate", func(ctx context.Context, m move) (bool, error) {
+		if PeerFrom(ctx) == nil {
+			t.Error("handler does not know the caller")
+		}
+		if m.X < 0 {
+			return false, errors.New("off the board")
+		}
+		return m.X == m.Y, nil
+	})
+	canceled := make(chan struct{})
+	Handle(server, "slow", func(ctx context.Context, _ struct{}) (struct{}, error) {
+		<-ctx.Done()
+		close(canceled)
+		return struct{}{}, ctx.Err()
+	})
+	HandleStream(server, "count", func(ctx context.Context, n int, send func(int) error) error {
+		for i := range n {
+			if err := send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	guest, owner := Over(a, nil), Over(b, server)
+	defer owner.Close()
+	ctx := context.Background()
+
+	if ok, err := Call[bool](ctx, guest, "validate", move{2, 2}); err != nil || !ok {
+		t.Errorf("got %v, %v", ok, err)
+	}
+	_, err := Call[bool](ctx, guest, "validate", move{-1, 2})
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Message != "off the board" {
+		t.Errorf("handler error came back as %v", err)
+	}
+	if _, err := Call[bool](ctx, guest, "missing", nil); !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("unknown method returned %v", err)
+	}
+	if _, err := Call[bool](ctx, owner, "validate", move{}); !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("peer without server returned %v", err)
+	}
+
+	stream, err := CallStream[int](ctx, guest, "count", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if n, err := stream.Recv(); err != nil || n != i {
+			t.Errorf("stream item %d: got %v, %v", i, n, err)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("stream ended with %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := Call[struct{}](timeout, guest, "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timed out call returned %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("handler was not canceled")
+	}
+
+	guest.Close()
+	if _, err := Call[bool](ctx, guest, "validate", move{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("call on closed peer returned %v", err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	server := NewServer()
+	Handle(server, "ready", func(ctx context.Context, _ struct{}) (string, error) { return "ready", nil })
+	group := NewGroup()
+	for range 3 {
+		a, b := pipetest.Pipe(64)
+		Over(b, server)
+		group.Add(Over(a, nil))
+	}
+	results := Broadcast[string](context.Background(), group, "ready", nil)
+	if len(results) != 3 {
+		t.Fatalf("%d results", len(results))
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Resp != "ready" {
+			t.Errorf("got %v, %v", r.Resp, r.Err)
+		}
+	}
+	results[0].Peer.Close()
+	time.Sleep(10 * time.Millisecond)
+	if n := len(group.Peers()); n != 2 {
+		t.Errorf("closed peer still in group, %d peers", n)
+	}
+}
+
+func TestCallWithoutResult(t *testing.T) {
+	a, b := pipetest.Pipe(64)
+	server := NewServer()
+	reset := false
+	Handle(server, "reset", func(ctx context.Context, _ struct{}) (any, error) {
+		reset = true
+		return nil, nil
+	})
+	HandleStream(server, "none", func(ctx context.Context, _ struct{}, send func(int) error) error {
+		return nil
+	})
+	guest, owner := Over(a, nil), Over(b, server)
+	defer owner.Close()
+	defer guest.Close()
+	ctx := context.Background()
+
+	if resp, err := Call[any](ctx, guest, "reset", nil); err != nil || resp != nil || !reset {
+		t.Errorf("got %v, %v", resp, err)
+	}
+	// a stream that ended before its first response
+	if n, err := Call[int](ctx, guest, "none", nil); err != nil || n != 0 {
+		t.Errorf("got %v, %v", n, err)
+	}
+}
@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Server holds the methods a [Peer] serves.
+// One server can be shared by many peers.
+type Server struct {
+	mu      sync.RWMutex
+	methods map[string]handler
+}
+
+// runs a call, sending its responses with send
+type handler func(ctx context.Context, body []byte, send func(resp any) error) (resp any, err error)
+
+func NewServer() *Server {
+	return &Server{methods: map[string]handler{}}
+}
+
+// Handle registers f as method. Registering a method again replaces it.
+// f runs on its own goroutine, ctx is canceled when the caller gives up.
+// Errors returned by f are passed to the caller as an [*Error].
+func Handle[Req, Resp any](s *Server, method string, f func(ctx context.Context, req Req) (Resp, error)) {
+	s.register(method, func(ctx context.Context, body []byte, _ func(any) error) (any, error) {
+		var req Req
+		if err := msgpack.Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("decoding request: %w", err)
+		}
+		return f(ctx, req)
+	})
+}
+
+// HandleStream registers f as a method that responds with a stream of values.
+// Every call of send delivers one value to the caller, the stream ends when f returns.
+func HandleStream[Req, Resp any](s *Server, method string, f func(ctx context.Context, req Req, send func(Resp) error) error) {
+	s.register(method, func(ctx context.Context, body []byte, send func(any) error) (any, error) {
+		var req Req
+		if err := msgpack.Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("decoding request: %w", err)
+		}
+		return nil, f(ctx, req, func(resp Resp) error { return send(resp) })
+	})
+}
+
+func (s *Server) register(method string, h handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[method] = h
+}
+
+func (s *Server) handler(method string) (handler, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.methods[method]
+	return h, ok
+}
+
+type peerKey struct{}
+
+// PeerFrom returns the peer that made the call being handled with ctx.
+func PeerFrom(ctx context.Context) *Peer {
+	p, _ := ctx.Value(peerKey{}).(*Peer)
+	return p
+}
+
+// handle a call of the remote
+func (p *Peer) serve(call frame) {
+	h, ok := p.server.handler(call.Method)
+	if !ok {
+		p.send(frame{Kind: frameError, ID: call.ID, Code: codeUnknownMethod, Error: ErrUnknownMethod.Error()})
+		return
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(p.ctx, peerKey{}, p))
+	if call.Timeout > 0 {
+		cancel()
+		ctx, cancel = context.WithTimeout(context.WithValue(p.ctx, peerKey{}, p), call.Timeout)
+	}
+	p.mu.Lock()
+	if old, ok := p.handling[call.ID]; ok {
+		old()
+	}
+	p.handling[call.ID] = cancel
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			delete(p.handling, call.ID)
+			p.mu.Unlock()
+			cancel()
+		}()
+		send := func(resp any) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			body, err := msgpack.Marshal(resp)
+			if err != nil {
+				return err
+			}
+			return p.send(frame{Kind: frameItem, ID: call.ID, Body: body})
+		}
+		resp, err := h(ctx, call.Body, send)
+		if ctx.Err() == context.Canceled {
+			return // the caller is gone
+		}
+		if err != nil {
+			code := codeHandler
+			if ctx.Err() == context.DeadlineExceeded {
+				code = codeDeadlineExceeded
+			}
+			p.send(frame{Kind: frameError, ID: call.ID, Code: code, Error: err.Error()})
+			return
+		}
+		reply := frame{Kind: frameReply, ID: call.ID}
+		if resp != nil {
+			reply.Body, err = msgpack.Marshal(resp)
+			if err != nil {
+				p.send(frame{Kind: frameError, ID: call.ID, Error: fmt.Sprintf("encoding response: %v", err)})
+				return
+			}
+		}
+		p.send(reply)
+	}()
+}
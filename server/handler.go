@@ -59,8 +59,10 @@ func WsHandler(w http.ResponseWriter, r *http.Request) {
 func ownerMsgTypesToForwardToGuest(typ message.Type) bool {
 	switch typ {
 	case message.IceAuthResponse,
+		message.IceRestartResponse,
 		message.IceCandidatesEnd,
-		message.IceCandidateForGuest:
+		message.IceCandidateForGuest,
+		message.ResumeRejected:
 		return true
 	default:
 		return false
@@ -72,8 +74,10 @@ func guestMsgTypesToForwardToOwner(typ message.Type) bool {
 	switch typ {
 	case
 		message.IceAuthInitiate,
+		message.IceRestart,
 		message.IceCandidatesEnd,
-		message.IceCandidateForOwner:
+		message.IceCandidateForOwner,
+		message.Leave:
 		return true
 	default:
 		return false
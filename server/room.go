@@ -61,6 +61,25 @@ func (room *Room) WriteToWebsocket(id uuid.UUID, msg message.Msg) {
 		}
 	}
 }
+
+// send msg to every guest and close their connections, then shut the room down
+func (room *Room) Close(msg message.Msg) {
+	room.Lock()
+	guests := make([]*Connection, 0, len(room.Connections))
+	for id, connection := range room.Connections {
+		if id != room.OwnerID {
+			guests = append(guests, connection)
+		}
+	}
+	room.Unlock()
+	for _, guest := range guests {
+		room.WriteToWebsocket(guest.ID, msg)
+		guest.conn.Close(websocket.StatusNormalClosure, "room closed")
+		room.Delete(guest.ID)
+	}
+	room.shutdown()
+}
+
 func newRoom() *Room {
 	room := Room{
 		ID:          rand.Text()[:6],
@@ -148,6 +167,10 @@ func (connection *Connection) Listen(room *Room, shutdownCtx context.Context) {
 							conn.conn.Close(websocket.StatusPolicyViolation, "kicked by owner")
 							room.Delete(msg.To)
 						}
+					case message.RoomClosed:
+						// owner is leaving, tell every guest before shutting down
+						room.Close(msg)
+						return
 					default:
 						slog.Debug("unallowed message type sent by owner")
 					}
@@ -160,6 +183,11 @@ func (connection *Connection) Listen(room *Room, shutdownCtx context.Context) {
 				// forward it to the owner
 				msg.From = connection.ID
 				room.WriteToWebsocket(room.OwnerID, msg)
+				if msg.Type == message.Leave {
+					connection.conn.Close(websocket.StatusNormalClosure, "left the room")
+					room.Delete(connection.ID)
+					return
+				}
 			}
 		}
 	}
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/coder/websocket"
+	"github.com/google/uuid"
 )
 
 func TestCreateRoom(t *testing.T) {
@@ -50,7 +51,7 @@ func TestCreateRoomAndJoin(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	go server.Serve(l, u)
+	go server.Serve(l, u.Path)
 	ctx := t.Context()
 	// owner conn
 	conn, _, err := websocket.Dial(ctx, u.String(), nil)
@@ -96,7 +97,7 @@ func TestCreateRoomAndJoin(t *testing.T) {
 		}
 
 		// forward a message to room owner
-		err = conn.Write(ctx, websocket.MessageBinary, message.IceAuthInitiateMsg("", "").Encode())
+		err = conn.Write(ctx, websocket.MessageBinary, message.IceAuthInitiateMsg("", "", uuid.New(), false, "", "", nil).Encode())
 		if err != nil {
 			t.Error(err)
 		}
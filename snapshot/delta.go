@@ -0,0 +1,88 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrCorruptDelta is returned for deltas that don't fit their baseline
+var ErrCorruptDelta = errors.New("corrupt snapshot delta")
+
+// unchanged stretches shorter than this are sent as changed bytes,
+// they would take more to encode as their own run
+const minUnchangedRun = 3
+
+// largest snapshot a delta may describe
+const maxSnapshotSize = 64 << 20
+
+// diff encodes next as the changes to base.
+// it starts with the length of next, followed by runs of
+// how many bytes are unchanged, how many changed, and the changed bytes.
+// bytes past the end of base count as zero.
+func diff(base, next []byte) []byte {
+	delta := binary.AppendUvarint(nil, uint64(len(next)))
+	i := 0
+	for i < len(next) {
+		start := i
+		for i < len(next) && next[i] == at(base, i) {
+			i++
+		}
+		changed := i
+		for i < len(next) {
+			if next[i] != at(base, i) {
+				i++
+				continue
+			}
+			j := i
+			for j < len(next) && j-i < minUnchangedRun && next[j] == at(base, j) {
+				j++
+			}
+			if j-i == minUnchangedRun || j == len(next) {
+				break
+			}
+			i = j
+		}
+		if i == changed {
+			break // the rest is unchanged
+		}
+		delta = binary.AppendUvarint(delta, uint64(changed-start))
+		delta = binary.AppendUvarint(delta, uint64(i-changed))
+		delta = append(delta, next[changed:i]...)
+	}
+	return delta
+}
+
+func at(b []byte, i int) byte {
+	if i < len(b) {
+		return b[i]
+	}
+	return 0
+}
+
+// patch applies a delta made by diff to base
+func patch(base, delta []byte) ([]byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 || size > maxSnapshotSize {
+		return nil, ErrCorruptDelta
+	}
+	delta = delta[n:]
+	next := make([]byte, size)
+	copy(next, base)
+	i := 0
+	for len(delta) > 0 {
+		same, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, ErrCorruptDelta
+		}
+		delta = delta[n:]
+		changed, n := binary.Uvarint(delta)
+		if n <= 0 || changed > uint64(len(delta)-n) || uint64(i)+same+changed > size {
+			return nil, ErrCorruptDelta
+		}
+		delta = delta[n:]
+		i += int(same)
+		i += copy(next[i:], delta[:changed])
+		delta = delta[changed:]
+	}
+	return next, nil
+}
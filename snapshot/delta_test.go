@@ -0,0 +1,44 @@
+package snapshot
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	base := make([]byte, 1000)
+	for i := range base {
+		base[i] = byte(rand.IntN(256))
+	}
+	grown := append(append([]byte(nil), base...), 1, 2, 3)
+	sparse := append([]byte(nil), base...)
+	for i := 0; i < len(sparse); i += 100 {
+		sparse[i]++
+	}
+	for name, next := range map[string][]byte{
+		"same":   base,
+		"sparse": sparse,
+		"grown":  grown,
+		"shrunk": base[:500],
+		"empty":  nil,
+		"zeros":  make([]byte, 5000),
+	} {
+		delta := diff(base, next)
+		got, err := patch(base, delta)
+		if err != nil || !bytes.Equal(got, next) {
+			t.Errorf("%s: did not survive the round trip, %v", name, err)
+		}
+		if name == "sparse" && len(delta) > 50 {
+			t.Errorf("delta of 10 changed bytes takes %d bytes", len(delta))
+		}
+	}
+	if got, _ := patch(nil, diff(nil, sparse)); !bytes.Equal(got, sparse) {
+		t.Error("full snapshot did not survive the round trip")
+	}
+	for _, corrupt := range [][]byte{nil, {10, 0, 5, 1}, {2, 5, 0}} {
+		if _, err := patch(base, corrupt); err != ErrCorruptDelta {
+			t.Errorf("patching with %v returned %v", corrupt, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"log/slog"
+
+	"github.com/BrownNPC/Ice-Data-Channel/client"
+)
+
+// Receiver rebuilds the snapshots a [Replicator] sends.
+// It is not safe to use from multiple goroutines.
+type Receiver struct {
+	conn client.MessageConn
+	// latest rebuilt snapshot
+	seq     uint32
+	history [historySize]rebuilt
+}
+
+type rebuilt struct {
+	seq   uint32
+	state []byte
+}
+
+// NewReceiver receives the snapshots sent to conn over channel [Channel]
+func NewReceiver(conn client.Conn) *Receiver {
+	return ReceiveOver(conn.Channel(Channel, client.UnreliableSequenced))
+}
+
+// ReceiveOver receives the snapshots sent over conn
+func ReceiveOver(conn client.MessageConn) *Receiver {
+	return &Receiver{conn: conn}
+}
+
+// Next blocks until a snapshot newer than the last one arrives, and returns it in full.
+// Snapshots that are lost or arrive late are skipped.
+// The returned state must not be modified, later snapshots are built on it.
+func (r *Receiver) Next() ([]byte, error) {
+	for {
+		msg, err := r.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if len(msg) < snapshotHeaderSize || msg[0] != kindSnapshot {
+			continue
+		}
+		seq := binary.BigEndian.Uint32(msg[1:])
+		base := binary.BigEndian.Uint32(msg[5:])
+		if seq <= r.seq {
+			continue // older than what we have
+		}
+		var baseline []byte
+		if base != 0 {
+			// the baseline is gone when the owner's acks lag far behind
+			b := r.history[base%historySize]
+			if b.seq != base {
+				continue
+			}
+			baseline = b.state
+		}
+		state, err := patch(baseline, msg[snapshotHeaderSize:])
+		if err != nil {
+			slog.Debug("dropping snapshot", "seq", seq, "error", err)
+			continue
+		}
+		r.seq = seq
+		r.history[seq%historySize] = rebuilt{seq, state}
+		ack := binary.BigEndian.AppendUint32([]byte{kindAck}, seq)
+		if _, err := r.conn.Write(ack); err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+}
+
+// Seq is the sequence number of the snapshot Next returned last, 0 before the first
+func (r *Receiver) Seq() uint32 { return r.seq }
@@ -0,0 +1,160 @@
+// Package snapshot replicates the state of an owner authoritative game to its guests.
+//
+// The owner wraps its state in a [Replicator] and calls Send every tick.
+// Every guest is sent the difference to the last snapshot it acknowledged,
+// over an unreliable channel of its [client.Conn], so a lost snapshot is
+// never sent again, the next one simply covers it:
+//
+//	rep := snapshot.NewReplicator(func() []byte { return world.Encode() })
+//	owner, err := client.NewOwner(ctx, rep.Add, cfg)
+//	...
+//	rep.Send()
+//
+// Guests rebuild the full state with a [Receiver]:
+//
+//	recv := snapshot.NewReceiver(guest.Conn())
+//	state, err := recv.Next()
+package snapshot
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"sync"
+
+	"github.com/BrownNPC/Ice-Data-Channel/client"
+)
+
+// Channel of a [client.Conn] that snapshots are sent over
+const Channel uint8 = 251
+
+// kinds of messages
+const (
+	kindSnapshot uint8 = iota + 1 // followed by the uint32 sequence number, the one of the baseline, and the delta
+	kindAck                       // followed by the sequence number of the snapshot that was rebuilt
+)
+
+const (
+	// snapshots kept as baselines. guests that acknowledged none of them get a full snapshot
+	historySize        = 64
+	snapshotHeaderSize = 1 + 4 + 4
+)
+
+// Source encodes the current state. Snapshots should keep the same
+// layout from one to the next, so that the deltas between them are small.
+// The returned slice is kept as a baseline and must not be modified afterwards.
+type Source func() []byte
+
+// Replicator sends snapshots of a [Source] to guests.
+// It is safe to use from multiple goroutines.
+type Replicator struct {
+	source Source
+
+	mu sync.Mutex
+	// sequence number of the latest snapshot, the first one is 1.
+	// 0 as a baseline means the snapshot is sent in full
+	seq     uint32
+	history [historySize][]byte
+	guests  map[client.MessageConn]*guest
+}
+
+type guest struct {
+	// latest snapshot the guest rebuilt
+	acked uint32
+}
+
+func NewReplicator(source Source) *Replicator {
+	return &Replicator{source: source, guests: map[client.MessageConn]*guest{}}
+}
+
+// Add replicates to the guest of conn, over channel [Channel].
+// The guest gets a full snapshot first, and deltas once it acknowledged one.
+func (r *Replicator) Add(conn client.Conn) {
+	r.AddOver(conn.Channel(Channel, client.UnreliableSequenced))
+}
+
+// AddOver replicates over conn, until reading from it fails or it is removed.
+func (r *Replicator) AddOver(conn client.MessageConn) {
+	r.mu.Lock()
+	r.guests[conn] = &guest{}
+	r.mu.Unlock()
+	go r.readAcks(conn)
+}
+
+// Remove stops replicating to the guest of conn
+func (r *Replicator) Remove(conn client.Conn) {
+	r.RemoveOver(conn.Channel(Channel, client.UnreliableSequenced))
+}
+
+func (r *Replicator) RemoveOver(conn client.MessageConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.guests, conn)
+}
+
+// Send takes a snapshot of the source and sends every guest
+// its difference to the last snapshot that guest acknowledged.
+func (r *Replicator) Send() {
+	state := r.source()
+	r.mu.Lock()
+	r.seq++
+	seq := r.seq
+	r.history[seq%historySize] = state
+	type send struct {
+		conn client.MessageConn
+		msg  []byte
+	}
+	// deltas are shared by guests on the same baseline
+	deltas := map[uint32][]byte{}
+	sends := make([]send, 0, len(r.guests))
+	for conn, g := range r.guests {
+		base := g.acked
+		if seq-base >= historySize {
+			base = 0
+		}
+		msg, ok := deltas[base]
+		if !ok {
+			msg = make([]byte, 0, snapshotHeaderSize+len(state))
+			msg = append(msg, kindSnapshot)
+			msg = binary.BigEndian.AppendUint32(msg, seq)
+			msg = binary.BigEndian.AppendUint32(msg, base)
+			msg = append(msg, diff(r.baseline(base), state)...)
+			deltas[base] = msg
+		}
+		sends = append(sends, send{conn, msg})
+	}
+	r.mu.Unlock()
+
+	for _, s := range sends {
+		if _, err := s.conn.Write(s.msg); err != nil {
+			slog.Debug("failed to send snapshot", "error", err)
+		}
+	}
+}
+
+// snapshot seq, or nothing for the full snapshot. must be called with r.mu held
+func (r *Replicator) baseline(seq uint32) []byte {
+	if seq == 0 {
+		return nil
+	}
+	return r.history[seq%historySize]
+}
+
+func (r *Replicator) readAcks(conn client.MessageConn) {
+	defer r.RemoveOver(conn)
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(msg) < 5 || msg[0] != kindAck {
+			continue
+		}
+		seq := binary.BigEndian.Uint32(msg[1:])
+		r.mu.Lock()
+		// acks can arrive out of order, and never for snapshots not sent yet
+		if g, ok := r.guests[conn]; ok && seq > g.acked && seq <= r.seq {
+			g.acked = seq
+		}
+		r.mu.Unlock()
+	}
+}
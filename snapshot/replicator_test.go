@@ -0,0 +1,72 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrownNPC/Ice-Data-Channel/internal/pipetest"
+)
+
+func TestReplication(t *testing.T) {
+	// 100 entities, one of them moves every tick
+	world := make([]byte, 800)
+	var mu sync.Mutex
+	rep := NewReplicator(func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]byte(nil), world...)
+	})
+	owner, guest := pipetest.Pipe(64)
+	owner.Lossy(3)
+	rep.AddOver(owner)
+	recv := ReceiveOver(guest)
+
+	received := 0
+	for tick := range 300 {
+		mu.Lock()
+		binary.BigEndian.PutUint64(world[tick%100*8:], uint64(tick))
+		want := append([]byte(nil), world...)
+		mu.Unlock()
+		rep.Send()
+		if tick%3 == 2 {
+			continue // lost
+		}
+		state, err := recv.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(state, want) {
+			t.Fatalf("tick %d: rebuilt the wrong state", tick)
+		}
+		received++
+		// wait for the ack, so the next delta is against this snapshot
+		for deadline := time.Now().Add(time.Second); rep.acked(owner) != recv.Seq(); {
+			if time.Now().After(deadline) {
+				t.Fatal("snapshot was not acknowledged")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if recv.Seq() != 299 {
+		t.Errorf("last snapshot was %d", recv.Seq())
+	}
+	// everything but the first snapshot should be a small delta
+	if owner.Bytes() > 800+received*100 {
+		t.Errorf("sent %d bytes for %d snapshots", owner.Bytes(), received)
+	}
+
+	rep.RemoveOver(owner)
+	rep.Send()
+	if guest.Buffered() > 0 {
+		t.Error("snapshot sent to a removed guest")
+	}
+}
+
+func (r *Replicator) acked(conn *pipetest.End) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.guests[conn].acked
+}